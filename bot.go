@@ -50,6 +50,8 @@ func (c *Collector) MustGet(request *Request, page *rod.Page, URL *url.URL, dept
 		request.URL = URL
 	}
 
+	c.emitRequest(EventNavigated, request, nil)
+
 	return &Response{
 		Request: request,
 		Page:    page,
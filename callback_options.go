@@ -1,9 +1,26 @@
 package roddy
 
-import "github.com/go-rod/rod"
+import (
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-rod/rod"
+)
 
 type CallbackOptions struct {
 	deferFunc func(p *rod.Page)
+
+	urlFilter *regexp.Regexp
+	minDepth  int
+	maxDepth  int
+	once      bool
+	callback  string
+
+	optional bool
+	waitFor  time.Duration
+
+	priority int
 }
 
 type CallbackOptionFunc func(o *CallbackOptions)
@@ -14,8 +31,142 @@ func bindCallbackOptions(opt *CallbackOptions, opts ...CallbackOptionFunc) {
 	}
 }
 
+func newCallbackOptions(opts ...CallbackOptionFunc) *CallbackOptions {
+	opt := &CallbackOptions{deferFunc: func(p *rod.Page) {}}
+	bindCallbackOptions(opt, opts...)
+
+	return opt
+}
+
 func WithDeferFunc(fn func(p *rod.Page)) CallbackOptionFunc {
 	return func(o *CallbackOptions) {
 		o.deferFunc = fn
 	}
 }
+
+// ForURL runs the callback only on the responses whose URL matches re.
+func ForURL(re *regexp.Regexp) CallbackOptionFunc {
+	return func(o *CallbackOptions) {
+		o.urlFilter = re
+	}
+}
+
+// ForDepth runs the callback only on the responses of depth in [min, max],
+// set max to 0 for no upper limit.
+func ForDepth(min, max int) CallbackOptionFunc {
+	return func(o *CallbackOptions) {
+		o.minDepth = min
+		o.maxDepth = max
+	}
+}
+
+// ForCallback runs the callback only on the pages followed by the rules named name,
+// it works on OnHTML, OnData and OnPaging.
+func ForCallback(name string) CallbackOptionFunc {
+	return func(o *CallbackOptions) {
+		o.callback = name
+	}
+}
+
+// Once runs the callback only on the first matched response it actually runs on,
+// a response missing the selector of an Optional callback, or whose callback
+// returns error, doesn't count.
+func Once() CallbackOptionFunc {
+	return func(o *CallbackOptions) {
+		o.once = true
+	}
+}
+
+// Optional skips the callback quietly if its selector is not found,
+// it works on OnHTML and OnPaging.
+func Optional() CallbackOptionFunc {
+	return func(o *CallbackOptions) {
+		o.optional = true
+	}
+}
+
+// Required aborts the response with a *MissingSelectorError if the selector is not found,
+// it's the default behavior of OnHTML and OnPaging.
+func Required() CallbackOptionFunc {
+	return func(o *CallbackOptions) {
+		o.optional = false
+	}
+}
+
+// WaitFor polls the selector until it appears or timeout, it's useful for
+// the elements rendered after page load. It works on OnHTML and OnPaging.
+func WaitFor(timeout time.Duration) CallbackOptionFunc {
+	return func(o *CallbackOptions) {
+		o.waitFor = timeout
+	}
+}
+
+// Priority sets the order of callbacks, callbacks of higher priority run first,
+// and callbacks of the same priority run in registration order. It's 0 by default.
+func Priority(n int) CallbackOptionFunc {
+	return func(o *CallbackOptions) {
+		o.priority = n
+	}
+}
+
+// callbackFilter decides which responses a callback applies to
+type callbackFilter struct {
+	urlFilter *regexp.Regexp
+	minDepth  int
+	maxDepth  int
+	once      bool
+	callback  string
+	fired     atomic.Bool
+}
+
+func newCallbackFilter(opt *CallbackOptions) *callbackFilter {
+	return &callbackFilter{
+		urlFilter: opt.urlFilter,
+		minDepth:  opt.minDepth,
+		maxDepth:  opt.maxDepth,
+		once:      opt.once,
+		callback:  opt.callback,
+	}
+}
+
+// applies returns true if the callback should run on r, it has no side effect,
+// the caller calls fire right before running the callback.
+func (f *callbackFilter) applies(r *Request) bool {
+	if f == nil {
+		return true
+	}
+
+	if f.urlFilter != nil && (r.URL == nil || !f.urlFilter.MatchString(r.URL.String())) {
+		return false
+	}
+
+	if r.Depth < f.minDepth || (f.maxDepth > 0 && r.Depth > f.maxDepth) {
+		return false
+	}
+
+	if f.callback != "" && followedBy(r) != f.callback {
+		return false
+	}
+
+	return !f.once || !f.fired.Load()
+}
+
+// fire claims the run of a Once callback right before it runs, it returns false if
+// it has already fired, so the callback runs once even if it's applied concurrently.
+func (f *callbackFilter) fire() bool {
+	if f == nil || !f.once {
+		return true
+	}
+
+	return f.fired.CompareAndSwap(false, true)
+}
+
+// misfire gives back the run claimed by fire if the callback fails,
+// so a Once callback runs again on the next response.
+func (f *callbackFilter) misfire() {
+	if f == nil || !f.once {
+		return
+	}
+
+	f.fired.Store(false)
+}
@@ -0,0 +1,119 @@
+package roddy
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// CallbackHandle is returned by the On* registrations, it detaches the registered callback.
+type CallbackHandle struct {
+	detach func()
+}
+
+// Detach deregisters the callback, it's safe to call in a running callback,
+// and the callback is not executed again, even in the current round.
+func (h *CallbackHandle) Detach() {
+	h.detach()
+}
+
+// callbackList is a copy-on-write list of callbacks ordered by priority.
+// Callbacks are iterated on a snapshot without lock, so registering and
+// detaching in a running callback never blocks.
+type callbackList[T any] struct {
+	lock    sync.Mutex
+	entries atomic.Pointer[[]*callbackEntry[T]]
+}
+
+type callbackEntry[T any] struct {
+	value    T
+	priority int
+	detached atomic.Bool
+}
+
+// add appends v after the callbacks of the same or higher priority
+func (l *callbackList[T]) add(v T, priority int) *CallbackHandle {
+	e := &callbackEntry[T]{value: v, priority: priority}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	old := l.snapshot()
+	entries := make([]*callbackEntry[T], 0, len(old)+1)
+	entries = append(entries, old...)
+	entries = append(entries, e)
+
+	// stable, so callbacks of the same priority keep the registration order
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].priority > entries[j].priority
+	})
+
+	l.entries.Store(&entries)
+
+	return &CallbackHandle{detach: func() { l.remove(e) }}
+}
+
+// removeFirst detaches the first callback which fn returns true, and returns true if found
+func (l *callbackList[T]) removeFirst(fn func(v T) bool) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	old := l.snapshot()
+
+	for i, e := range old {
+		if !fn(e.value) {
+			continue
+		}
+
+		e.detached.Store(true)
+
+		entries := make([]*callbackEntry[T], 0, len(old)-1)
+		entries = append(entries, old[:i]...)
+		entries = append(entries, old[i+1:]...)
+		l.entries.Store(&entries)
+
+		return true
+	}
+
+	return false
+}
+
+func (l *callbackList[T]) remove(e *callbackEntry[T]) {
+	e.detached.Store(true)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	old := l.snapshot()
+	entries := make([]*callbackEntry[T], 0, len(old))
+
+	for _, v := range old {
+		if v != e {
+			entries = append(entries, v)
+		}
+	}
+
+	l.entries.Store(&entries)
+}
+
+// snapshot returns the current entries, the returned slice is never modified
+func (l *callbackList[T]) snapshot() []*callbackEntry[T] {
+	if p := l.entries.Load(); p != nil {
+		return *p
+	}
+
+	return nil
+}
+
+func (l *callbackList[T]) len() int {
+	return len(l.snapshot())
+}
+
+// each calls fn with the callbacks in order, the callbacks detached in the meantime are skipped.
+func (l *callbackList[T]) each(fn func(v T)) {
+	for _, e := range l.snapshot() {
+		if !e.detached.Load() {
+			fn(e.value)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package roddy
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/stretchr/testify/suite"
+)
+
+type CallbacksSuite struct {
+	suite.Suite
+	ts *httptest.Server
+}
+
+func TestCallbacks(t *testing.T) {
+	suite.Run(t, new(CallbacksSuite))
+}
+
+func (s *CallbacksSuite) SetupSuite() {
+	s.ts = newTestServer()
+}
+
+func (s *CallbacksSuite) TearDownSuite() {
+	s.ts.Close()
+}
+
+func (s *CallbacksSuite) Test_00_CallbackFilter() {
+	request := func(uri string, depth int) *Request {
+		u, _ := ParseUrl(uri)
+		return &Request{URL: u, Depth: depth}
+	}
+
+	newFilter := func(opts ...CallbackOptionFunc) *callbackFilter {
+		opt := CallbackOptions{}
+		bindCallbackOptions(&opt, opts...)
+
+		return newCallbackFilter(&opt)
+	}
+
+	var noFilter *callbackFilter
+	s.True(noFilter.applies(request("https://example.com/", 1)))
+
+	f := newFilter(ForURL(regexp.MustCompile(`/detail/`)), ForDepth(2, 3))
+	s.True(f.applies(request("https://example.com/detail/1", 2)))
+	s.False(f.applies(request("https://example.com/list", 2)))
+	s.False(f.applies(request("https://example.com/detail/1", 1)))
+	s.False(f.applies(request("https://example.com/detail/1", 4)))
+
+	once := newFilter(ForDepth(2, 0), Once())
+	s.False(once.applies(request("https://example.com/", 1)))
+	s.True(once.applies(request("https://example.com/", 9)))
+	s.True(once.applies(request("https://example.com/", 2)), "applies until it fires, e.g. optional selector not found")
+	s.True(once.fire())
+	s.False(once.applies(request("https://example.com/", 2)))
+	s.False(once.fire())
+	s.True(noFilter.fire())
+
+	once.misfire()
+	s.True(once.applies(request("https://example.com/", 2)), "failed callback runs again")
+	s.True(once.fire())
+
+	noFilter.misfire()
+}
+
+func (s *CallbacksSuite) Test_01_MissingSelectorError() {
+	var err error = &MissingSelectorError{URL: "https://example.com/", Selector: "div.banner", Callback: "html"}
+
+	s.ErrorIs(err, ErrNoElemFound)
+	s.Equal(`https://example.com/: html selector "div.banner": No element found`, err.Error())
+
+	var mse *MissingSelectorError
+	s.ErrorAs(fmt.Errorf("wrapped: %w", err), &mse)
+	s.Equal("div.banner", mse.Selector)
+
+	opt := CallbackOptions{}
+	bindCallbackOptions(&opt, Optional(), WaitFor(time.Second))
+	s.True(opt.optional)
+	s.Equal(time.Second, opt.waitFor)
+
+	bindCallbackOptions(&opt, Required())
+	s.False(opt.optional)
+}
+
+func (s *CallbacksSuite) Test_02_CallbackHandle() {
+	c := NewCollector()
+
+	var order []string
+
+	c.OnRequest(func(r *Request) { order = append(order, "default") })
+	c.OnRequest(func(r *Request) { order = append(order, "low") }, Priority(-1))
+
+	var self *CallbackHandle
+	self = c.OnRequest(func(r *Request) {
+		order = append(order, "high-once")
+		self.Detach()
+	}, Priority(10))
+
+	later := c.OnRequest(func(r *Request) { order = append(order, "detached") }, Priority(-2))
+	c.OnRequest(func(r *Request) {
+		order = append(order, "default2")
+		later.Detach()
+	})
+
+	c.handleOnRequest(&Request{})
+	s.Equal([]string{"high-once", "default", "default2", "low"}, order, "detached in the same round is skipped")
+
+	order = nil
+	c.handleOnRequest(&Request{})
+	s.Equal([]string{"default", "default2", "low"}, order)
+
+	for i := 0; i < 3; i++ {
+		c.OnHTML("div.banner", func(e *SerpElement) error { return nil })
+	}
+
+	c.OnHTML("a[href]", func(e *SerpElement) error { return nil })
+	c.OnHTMLDetach("div.banner")
+	s.Equal(3, c.htmlCallbacks.len(), "only the first one is detached")
+	s.Equal("a[href]", c.htmlCallbacks.snapshot()[2].value.Selector)
+}
+
+func (s *CallbacksSuite) Test_03_MissingSelector() {
+	var called []string
+
+	record := func(name string) HTMLCallback {
+		return func(e *SerpElement) error {
+			called = append(called, name)
+			return nil
+		}
+	}
+
+	// Required is the default
+	c := NewCollector()
+	c.OnHTML("div.missing", record("missing"))
+	c.OnHTML("title", record("title"))
+
+	var onErr error
+
+	c.OnError(func(r *Response, err error) { onErr = err })
+
+	err := c.Visit(s.ts.URL + "/html")
+
+	var mse *MissingSelectorError
+	s.ErrorAs(err, &mse)
+	s.Equal("div.missing", mse.Selector)
+	s.Equal("html", mse.Callback)
+	s.ErrorIs(onErr, ErrNoElemFound)
+	s.Empty(called, "the response is aborted")
+
+	// Optional is skipped with its DeferFunc, and a skipped Once callback keeps its run
+	called = nil
+	c = NewCollector()
+	c.OnHTML("div.missing", record("missing"), Optional(), WithDeferFunc(func(p *rod.Page) {
+		called = append(called, "missing deferred")
+	}))
+	c.OnHTML("p.description", record("once"), Optional(), Once())
+	c.OnHTML("title", record("title"), WithDeferFunc(func(p *rod.Page) {
+		called = append(called, "title deferred")
+	}))
+
+	s.Nil(c.Visit(s.ts.URL + "/list?page=1"))
+	s.Nil(c.Visit(s.ts.URL + "/html"))
+	s.Nil(c.Visit(s.ts.URL + "/list?page=2"))
+	s.Equal([]string{
+		"title", "title deferred",
+		"once", "once", "title", "title deferred",
+		"title", "title deferred",
+	}, called)
+
+	// a failed Once callback runs again
+	called = nil
+	c = NewCollector()
+	c.OnHTML("title", func(e *SerpElement) error {
+		called = append(called, e.Request.URL.Path)
+		if len(called) == 1 {
+			return errors.New("boom")
+		}
+
+		return nil
+	}, Once())
+
+	s.NotNil(c.Visit(s.ts.URL + "/html"))
+	s.Nil(c.Visit(s.ts.URL + "/list?page=1"))
+	s.Nil(c.Visit(s.ts.URL + "/list?page=2"))
+	s.Equal([]string{"/html", "/list"}, called)
+
+	// WaitFor polls the element rendered after load
+	called = nil
+	c = NewCollector()
+	c.OnHTML("div.late", record("now"), Optional())
+	c.OnHTML("div.late", record("late"), WaitFor(3*time.Second))
+
+	s.Nil(c.Visit(s.ts.URL + "/late"))
+	s.Equal([]string{"late"}, called)
+
+	c = NewCollector()
+	c.OnHTML("div.late", record("late"), WaitFor(100*time.Millisecond))
+
+	s.ErrorAs(c.Visit(s.ts.URL+"/late"), &mse, "not rendered in time")
+}
@@ -0,0 +1,185 @@
+package roddy
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// _trackingParams are the query params stripped by NewCanonicalizer
+var _trackingParams = []string{"utm_*", "gclid", "fbclid", "msclkid", "yclid", "mc_cid", "mc_eid", "_ga"}
+
+// Canonicalizer rewrites URLs to their canonical form,
+// so the variants of a page are deduplicated as the same page.
+type Canonicalizer struct {
+	// StripParams are the query params to remove, a trailing "*" matches by prefix, e.g. "utm_*"
+	StripParams []string
+	// SortQuery sorts the query params by key
+	SortQuery bool
+	// LowercaseHost lowercases the host
+	LowercaseHost bool
+	// StripTrailingSlash removes the trailing slash of the path, except the root path
+	StripTrailingSlash bool
+	// KeepHashRoutes keeps the fragments of hash routing, like "#/item/1" or "#!/item/1",
+	// other fragments are dropped.
+	KeepHashRoutes bool
+	// HashRoutingHosts keep all fragments of the hosts, as each fragment is a page of these sites.
+	HashRoutingHosts []string
+	// RelCanonical marks the rel=canonical URL of a loaded page as visited too,
+	// so the canonical page is not visited again by another variant.
+	RelCanonical bool
+}
+
+// NewCanonicalizer creates a Canonicalizer which strips the common tracking params,
+// sorts query, lowercases host, strips trailing slash, keeps hash routes and respects rel=canonical.
+func NewCanonicalizer() *Canonicalizer {
+	return &Canonicalizer{
+		StripParams:        _trackingParams,
+		SortQuery:          true,
+		LowercaseHost:      true,
+		StripTrailingSlash: true,
+		KeepHashRoutes:     true,
+		RelCanonical:       true,
+	}
+}
+
+// WithCanonicalizer canonicalizes URLs for visited checking and Request.AbsoluteURL.
+func WithCanonicalizer(cz *Canonicalizer) CollectorOption {
+	return func(c *Collector) {
+		c.canonicalizer = cz
+	}
+}
+
+// Canonicalize returns the canonical form of u, u is returned as is if it cannot be parsed.
+func (cz *Canonicalizer) Canonicalize(u string) string {
+	parsed, err := ParseUrl(u)
+	if err != nil {
+		return u
+	}
+
+	if cz.LowercaseHost {
+		parsed.Host = strings.ToLower(parsed.Host)
+	}
+
+	if parsed.RawQuery != "" {
+		parsed.RawQuery = cz.canonicalQuery(parsed.RawQuery)
+	}
+
+	if cz.StripTrailingSlash && len(parsed.Path) > 1 && strings.HasSuffix(parsed.Path, "/") {
+		parsed.Path = strings.TrimRight(parsed.Path, "/")
+		parsed.RawPath = ""
+
+		if parsed.Path == "" {
+			parsed.Path = "/"
+		}
+	}
+
+	if !cz.keepFragment(parsed.Hostname(), parsed.Fragment) {
+		parsed.Fragment = ""
+		parsed.RawFragment = ""
+	}
+
+	return parsed.String()
+}
+
+// canonicalQuery works on the raw params, so the kept params are not re-encoded.
+func (cz *Canonicalizer) canonicalQuery(raw string) string {
+	params := strings.Split(raw, "&")
+	kept := params[:0]
+
+	for _, p := range params {
+		if p == "" || cz.isStripped(paramKey(p)) {
+			continue
+		}
+
+		kept = append(kept, p)
+	}
+
+	if cz.SortQuery {
+		// stable, so the values of a repeated key keep their order
+		sort.SliceStable(kept, func(i, j int) bool {
+			return paramKey(kept[i]) < paramKey(kept[j])
+		})
+	}
+
+	return strings.Join(kept, "&")
+}
+
+func (cz *Canonicalizer) isStripped(key string) bool {
+	for _, p := range cz.StripParams {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if key == p {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (cz *Canonicalizer) keepFragment(host, fragment string) bool {
+	if fragment == "" {
+		return false
+	}
+
+	for _, h := range cz.HashRoutingHosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+
+	return cz.KeepHashRoutes && (fragment[0] == '/' || fragment[0] == '!')
+}
+
+func paramKey(param string) string {
+	k, _, _ := strings.Cut(param, "=")
+	if unescaped, err := url.QueryUnescape(k); err == nil {
+		return unescaped
+	}
+
+	return k
+}
+
+// canonicalURL returns u canonicalized by the collector's Canonicalizer
+func (c *Collector) canonicalURL(u string) string {
+	if c.canonicalizer == nil {
+		return u
+	}
+
+	return c.canonicalizer.Canonicalize(u)
+}
+
+// markRelCanonical marks the rel=canonical URL of the loaded page as visited
+func (c *Collector) markRelCanonical(resp *Response) {
+	if c.canonicalizer == nil || !c.canonicalizer.RelCanonical || c.allowURLRevisit {
+		return
+	}
+
+	found, elem, err := resp.Page.Has(`link[rel="canonical"]`)
+	if err != nil || !found {
+		return
+	}
+
+	href, err := elem.Attribute("href")
+	if err != nil || href == nil {
+		return
+	}
+
+	u, err := urlParser.ParseRef(resp.Request.URL.String(), *href)
+	if err != nil {
+		return
+	}
+
+	canonical := c.canonicalURL(u.Href(false))
+	if canonical == c.canonicalURL(resp.Request.URL.String()) {
+		return
+	}
+
+	if err := c.store.Visited(requestHash(canonical, nil)); err != nil {
+		log.Error().Err(err).Str("canonical", canonical).Msg("cannot mark canonical url as visited")
+	}
+}
@@ -0,0 +1,47 @@
+package roddy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CanonicalSuite struct {
+	suite.Suite
+}
+
+func TestCanonical(t *testing.T) {
+	suite.Run(t, new(CanonicalSuite))
+}
+
+func (s *CanonicalSuite) Test_00_Canonicalize() {
+	cz := NewCanonicalizer()
+	cz.HashRoutingHosts = []string{"spa.example.com"}
+
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"https://Example.COM/a/?utm_source=x&b=2&a=1&utm_medium=y#top", "https://example.com/a?a=1&b=2"},
+		{"https://example.com/?gclid=1", "https://example.com/"},
+		{"https://example.com/list?b=2&a=1&b=1", "https://example.com/list?a=1&b=2&b=1"},
+		{"https://example.com/app#/item/1", "https://example.com/app#/item/1"},
+		{"https://spa.example.com/#item-1", "https://spa.example.com/#item-1"},
+	}
+	for _, tt := range tests {
+		s.Equal(tt.want, cz.Canonicalize(tt.uri), tt.uri)
+	}
+
+	c := NewCollector(WithCanonicalizer(cz))
+	u, _ := ParseUrl("https://example.com/list/")
+	r := &Request{URL: u, collector: c}
+
+	s.Equal("https://example.com/item?id=1", r.AbsoluteURL("item/../../item/?id=1&utm_campaign=z"))
+	s.Equal("", r.AbsoluteURL("#comments"))
+	s.Equal("https://example.com/list#/page/2", r.AbsoluteURL("#/page/2"))
+
+	s.Nil(c.checkVistedStatus(u))
+
+	variant, _ := ParseUrl("https://EXAMPLE.com/list?utm_source=feed")
+	s.IsType(&AlreadyVisitedError{}, c.checkVistedStatus(variant), "variants are deduplicated")
+}
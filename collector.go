@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"regexp"
 	"sync"
@@ -48,6 +49,9 @@ type Collector struct {
 	allowedDomains []string
 	// disallowedDomains is a domain blacklist.
 	disallowedDomains []string
+	// allowedMatcher and disallowedMatcher are the compiled domain lists, nil if the list is empty
+	allowedMatcher    *domainMatcher
+	disallowedMatcher *domainMatcher
 	// disallowedURLFilters is a list of regular expressions which restricts
 	// visiting URLs. If any of the rules matches to a URL the
 	// request will be stopped. disallowedURLFilters will
@@ -59,17 +63,34 @@ type Collector struct {
 
 	// allowURLRevisit allows multiple downloads of the same URL
 	allowURLRevisit bool
+	// revisitAfter allows downloading a visited URL again after it, 0 means never
+	revisitAfter time.Duration
+	// revisitRules are the per URL pattern revisitAfter, the first matched rule wins
+	revisitRules []revisitRule
+
+	// canonicalizer rewrites URLs to the canonical form for visited checking, disabled when nil
+	canonicalizer *Canonicalizer
+
+	// contentIndex holds the fingerprints of loaded pages for DedupContent
+	contentIndex *contentIndex
 
 	// store is used to identify if URL is visited or not
 	store storage.Storage
 
-	dataCallbacks     []*dataCallbackContainer
-	htmlCallbacks     []*htmlCallbackContainer
-	pagingCallbacks   []*htmlCallbackContainer
-	requestCallbacks  []RequestCallback
-	responseCallbacks []ResponseCallback
-	errorCallbacks    []ErrorCallback
-	scrapedCallbacks  []ScrapedCallback
+	// httpClient gets sitemaps and robots.txt, see sitemapClient
+	httpClient     *http.Client
+	httpClientOnce sync.Once
+
+	dataCallbacks     callbackList[*dataCallbackContainer]
+	htmlCallbacks     callbackList[*htmlCallbackContainer]
+	pagingCallbacks   callbackList[*htmlCallbackContainer]
+	requestCallbacks  callbackList[RequestCallback]
+	responseCallbacks callbackList[ResponseCallback]
+	errorCallbacks    callbackList[ErrorCallback]
+	scrapedCallbacks  callbackList[ScrapedCallback]
+
+	followRules callbackList[*ruleContainer]
+	paginators  callbackList[*paginatorContainer]
 
 	ignoredErrors     []error
 	ignoreVistedError bool
@@ -103,11 +124,16 @@ type Collector struct {
 
 	// limitRule *LimitRule
 
+	// middlewares wrap the fetch of each request
+	middlewares []Middleware
+
+	// eventSink receives the request lifecycle events, disabled when nil
+	eventSink EventSink
+	eventSeq  uint64
+
 	baseDir   string
 	cacheDir  string
 	cookieDir string
-
-	lock *sync.RWMutex
 }
 
 // AlreadyVisitedError is the error type for already visited URLs.
@@ -130,6 +156,27 @@ func (e *AlreadyVisitedError) Error() string {
 	return fmt.Sprintf("%q already visited", e.Destination)
 }
 
+// MissingSelectorError is the error of a required selector not found in the page.
+// It wraps ErrNoElemFound.
+type MissingSelectorError struct {
+	// URL is the URL of the page
+	URL string
+	// Selector is the missing selector
+	Selector string
+	// Callback is the kind of callback: html or paging
+	Callback string
+}
+
+// Error implements error interface.
+func (e *MissingSelectorError) Error() string {
+	return fmt.Sprintf("%s: %s selector %q: %s", e.URL, e.Callback, e.Selector, ErrNoElemFound)
+}
+
+// Unwrap returns ErrNoElemFound
+func (e *MissingSelectorError) Unwrap() error {
+	return ErrNoElemFound
+}
+
 var (
 	// ErrForbiddenDomain is the error thrown if visiting
 	// a domain which is not allowed in AllowedDomains
@@ -154,9 +201,35 @@ var (
 	ErrNoElemFound = errors.New("No element found")
 
 	// ErrQueueFull is the error returned when the queue is full
-	ErrQueueFull = errors.New("Queue MaxSize reached")
+	ErrQueueFull = storage.ErrQueueFull
+
+	// ErrDuplicateContent is the error returned when the page content is a duplicate of a loaded page
+	ErrDuplicateContent = errors.New("Duplicate content")
 )
 
+// IsSkipError reports whether err is returned by the request checks or limits,
+// such requests are skipped rather than failed.
+func IsSkipError(err error) bool {
+	for _, e := range []error{
+		ErrForbiddenDomain,
+		ErrMaxDepth,
+		ErrForbiddenURL,
+		ErrNoURLFiltersMatch,
+		ErrMaxRequests,
+		ErrMaxResponses,
+		ErrMaxPageNumReached,
+		ErrDuplicateContent,
+	} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+
+	var ave *AlreadyVisitedError
+
+	return errors.As(err, &ave)
+}
+
 func NewCollector(options ...CollectorOption) *Collector {
 	c := &Collector{}
 	// default settings
@@ -199,6 +272,42 @@ func AllowURLRevisit(b bool) CollectorOption {
 	}
 }
 
+// RevisitAfter allows visiting a URL again when d passed since its last visit.
+// It requires the storage implementing storage.VisitTimeStorage,
+// otherwise visited URLs are never visited again.
+func RevisitAfter(d time.Duration) CollectorOption {
+	return func(c *Collector) {
+		c.revisitAfter = d
+	}
+}
+
+// RevisitAfterFor is RevisitAfter of the URLs matching re, it overrides RevisitAfter,
+// and the first matched pattern wins. Set d to 0 to never revisit the matched URLs, e.g.
+//
+//	RevisitAfterFor(regexp.MustCompile(`/list`), time.Hour)
+//	RevisitAfterFor(regexp.MustCompile(`/detail/`), 0)
+func RevisitAfterFor(re *regexp.Regexp, d time.Duration) CollectorOption {
+	return func(c *Collector) {
+		c.revisitRules = append(c.revisitRules, revisitRule{re: re, ttl: d})
+	}
+}
+
+type revisitRule struct {
+	re  *regexp.Regexp
+	ttl time.Duration
+}
+
+// revisitTTL returns the revisitAfter of URL u
+func (c *Collector) revisitTTL(u string) time.Duration {
+	for _, r := range c.revisitRules {
+		if r.re.MatchString(u) {
+			return r.ttl
+		}
+	}
+
+	return c.revisitAfter
+}
+
 func SkipOnHTMLOfMaxDepth(b bool) CollectorOption {
 	return func(c *Collector) {
 		c.skipOnHTMLOfMaxDepth = b
@@ -233,16 +342,21 @@ func MaxPageNum(n uint32) CollectorOption {
 }
 
 // AllowedDomains sets the domain whitelist used by the Collector.
+// A registrable domain like "example.com" allows all its subdomains,
+// and "*.example.com" allows the subdomains only, a pattern with port only allows the port.
 func AllowedDomains(domains ...string) CollectorOption {
 	return func(c *Collector) {
 		c.allowedDomains = domains
+		c.allowedMatcher = newDomainMatcher(domains)
 	}
 }
 
-// AllowedDomains sets the domain whitelist used by the Collector.
+// DisallowedDomains sets the domain blacklist used by the Collector,
+// the patterns are the same as AllowedDomains.
 func DisallowedDomains(domains ...string) CollectorOption {
 	return func(c *Collector) {
 		c.disallowedDomains = domains
+		c.disallowedMatcher = newDomainMatcher(domains)
 	}
 }
 
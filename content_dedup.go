@@ -0,0 +1,233 @@
+package roddy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/rs/zerolog/log"
+)
+
+// DedupMode is how DedupContent compares the page contents
+type DedupMode int
+
+const (
+	dedupOff DedupMode = iota
+	// DedupExact skips pages with exactly the same normalized text
+	DedupExact
+	// DedupSimHash skips pages whose SimHash of text is within SimHashDistance bits
+	DedupSimHash
+)
+
+const (
+	_defaultSimHashDistance = 3
+	// words per shingle of SimHash
+	_shingleSize = 3
+	// the 64 bits hash is split into bands, two hashes within distance d
+	// share at least one band if there are more than d bands
+	_simHashBands = 4
+)
+
+// DedupContent fingerprints the normalized text of each loaded page, and skips
+// the duplicates before OnHTML/OnData run, the URLs of duplicates are recorded
+// as aliases of the first page, see Collector.ContentAliases.
+func DedupContent(mode DedupMode) CollectorOption {
+	return func(c *Collector) {
+		c.contentIndex.mode = mode
+	}
+}
+
+// SimHashDistance sets the max hamming distance of near duplicates in DedupSimHash mode,
+// it's at most 3, as larger distance makes too many false duplicates of 64 bits SimHash.
+func SimHashDistance(d int) CollectorOption {
+	return func(c *Collector) {
+		c.contentIndex.distance = min(max(d, 0), _simHashBands-1)
+	}
+}
+
+// ContentAliases returns the URLs of duplicate pages keyed by the URL of the first page.
+func (c *Collector) ContentAliases() map[string][]string {
+	return c.contentIndex.aliasesCopy()
+}
+
+// checkDuplicateContent returns ErrDuplicateContent if the page content is seen
+func (c *Collector) checkDuplicateContent(resp *Response) error {
+	if c.contentIndex.mode == dedupOff {
+		return nil
+	}
+
+	html, err := resp.Page.HTML()
+	if err != nil {
+		return err
+	}
+
+	u := resp.Request.URL.String()
+
+	origin, dup := c.contentIndex.add(u, normalizeText(html))
+	if !dup {
+		return nil
+	}
+
+	err = fmt.Errorf("%w: %s is an alias of %s", ErrDuplicateContent, u, origin)
+	log.Debug().Str("url", u).Str("origin", origin).Msg("skip duplicate content")
+	c.emitRequest(EventSkipped, resp.Request, err)
+
+	return err
+}
+
+// contentIndex holds the fingerprints of the pages
+type contentIndex struct {
+	mode     DedupMode
+	distance int
+
+	lock sync.Mutex
+	// exact is fingerprint to the first URL
+	exact map[uint64]string
+	// bands are the SimHash entries indexed by each 16 bits band
+	bands   [_simHashBands]map[uint16][]int
+	entries []simHashEntry
+	aliases map[string][]string
+}
+
+type simHashEntry struct {
+	hash uint64
+	url  string
+}
+
+func newContentIndex() *contentIndex {
+	ci := &contentIndex{
+		distance: _defaultSimHashDistance,
+		exact:    make(map[uint64]string),
+		aliases:  make(map[string][]string),
+	}
+
+	for i := range ci.bands {
+		ci.bands[i] = make(map[uint16][]int)
+	}
+
+	return ci
+}
+
+// add records text of u, and returns the URL of the first page if text is a duplicate
+func (ci *contentIndex) add(u, text string) (string, bool) {
+	ci.lock.Lock()
+	defer ci.lock.Unlock()
+
+	var origin string
+	if ci.mode == DedupSimHash {
+		origin = ci.addSimHash(u, simHash(text))
+	} else {
+		origin = ci.addExact(u, text)
+	}
+
+	if origin == "" || origin == u {
+		return "", false
+	}
+
+	ci.aliases[origin] = append(ci.aliases[origin], u)
+
+	return origin, true
+}
+
+func (ci *contentIndex) addExact(u, text string) string {
+	h := fnv.New64a()
+	h.Write([]byte(text))
+	sum := h.Sum64()
+
+	if origin, ok := ci.exact[sum]; ok {
+		return origin
+	}
+
+	ci.exact[sum] = u
+
+	return ""
+}
+
+func (ci *contentIndex) addSimHash(u string, hash uint64) string {
+	for i := range ci.bands {
+		for _, idx := range ci.bands[i][band(hash, i)] {
+			if bits.OnesCount64(ci.entries[idx].hash^hash) <= ci.distance {
+				return ci.entries[idx].url
+			}
+		}
+	}
+
+	ci.entries = append(ci.entries, simHashEntry{hash: hash, url: u})
+
+	for i := range ci.bands {
+		b := band(hash, i)
+		ci.bands[i][b] = append(ci.bands[i][b], len(ci.entries)-1)
+	}
+
+	return ""
+}
+
+func (ci *contentIndex) aliasesCopy() map[string][]string {
+	ci.lock.Lock()
+	defer ci.lock.Unlock()
+
+	m := make(map[string][]string, len(ci.aliases))
+	for k, v := range ci.aliases {
+		m[k] = append([]string(nil), v...)
+	}
+
+	return m
+}
+
+func band(hash uint64, i int) uint16 {
+	return uint16(hash >> (16 * i))
+}
+
+// normalizeText returns the lowercase visible text of body, with whitespaces collapsed.
+func normalizeText(html string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return strings.Join(strings.Fields(strings.ToLower(html)), " ")
+	}
+
+	doc.Find("script,style,noscript,template").Remove()
+
+	return strings.Join(strings.Fields(strings.ToLower(doc.Find("body").Text())), " ")
+}
+
+// simHash is the 64 bits SimHash of the word shingles of text
+func simHash(text string) uint64 {
+	words := strings.Fields(text)
+
+	var weights [64]int
+
+	addFeature := func(feature string) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+
+		for i := 0; i < 64; i++ {
+			if sum&(1<<i) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+
+	if len(words) < _shingleSize {
+		addFeature(strings.Join(words, " "))
+	}
+
+	for i := 0; i+_shingleSize <= len(words); i++ {
+		addFeature(strings.Join(words[i:i+_shingleSize], " "))
+	}
+
+	var hash uint64
+
+	for i, w := range weights {
+		if w > 0 {
+			hash |= 1 << i
+		}
+	}
+
+	return hash
+}
@@ -0,0 +1,56 @@
+package roddy
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ContentDedupSuite struct {
+	suite.Suite
+}
+
+func TestContentDedup(t *testing.T) {
+	suite.Run(t, new(ContentDedupSuite))
+}
+
+func (s *ContentDedupSuite) Test_00_DedupContent() {
+	page := func(body string) string {
+		return "<html><head><script>var session = 1;</script></head><body>" + body + "</body></html>"
+	}
+
+	article := strings.Repeat("the quick brown fox jumps over the lazy dog again and again ", 20)
+
+	s.Equal("hello world", normalizeText(page("<p>Hello</p>\n  <p>WORLD</p><style>p{}</style>")))
+
+	exact := newContentIndex()
+	exact.mode = DedupExact
+
+	_, dup := exact.add("https://example.com/a", normalizeText(page(article)))
+	s.False(dup)
+
+	origin, dup := exact.add("https://example.com/a?sid=1", normalizeText(page("  "+article)))
+	s.True(dup)
+	s.Equal("https://example.com/a", origin)
+
+	_, dup = exact.add("https://example.com/b", normalizeText(page(article+" updated")))
+	s.False(dup)
+
+	near := newContentIndex()
+	near.mode = DedupSimHash
+
+	_, dup = near.add("https://example.com/a", normalizeText(page(article+" footer 2023")))
+	s.False(dup)
+
+	origin, dup = near.add("https://example.com/print/a", normalizeText(page(article+" footer 2024")))
+	s.True(dup, "near duplicate")
+	s.Equal("https://example.com/a", origin)
+
+	_, dup = near.add("https://example.com/c", normalizeText(page("a completely different page about cooking pasta with tomato sauce")))
+	s.False(dup)
+
+	s.Equal(map[string][]string{"https://example.com/a": {"https://example.com/print/a"}}, near.aliasesCopy())
+	s.True(IsSkipError(fmt.Errorf("%w: alias", ErrDuplicateContent)))
+}
@@ -1,13 +1,62 @@
 package roddy
 
 import (
+	"encoding/json"
+	"math"
+	"reflect"
 	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
+// _contextTypesKey is the reserved key holding the registered type names in the JSON form of Context
+const _contextTypesKey = "@types"
+
+var (
+	contextTypes     = map[string]reflect.Type{}
+	contextTypeNames = map[reflect.Type]string{}
+	contextTypesLock sync.RWMutex
+)
+
+func init() {
+	RegisterContextType(int(0))
+	RegisterContextType(int64(0))
+	RegisterContextType(time.Time{})
+}
+
+// RegisterContextType registers the type of value, so values of the type
+// are decoded back to the same type after a Marshal/UnmarshalRequest round trip.
+// Values of unregistered types are decoded as the JSON defaults, e.g. numbers as float64.
+func RegisterContextType(value interface{}) {
+	typ := reflect.TypeOf(value)
+	name := contextTypeName(typ)
+
+	contextTypesLock.Lock()
+	defer contextTypesLock.Unlock()
+
+	contextTypes[name] = typ
+	contextTypeNames[typ] = name
+}
+
+func contextTypeName(typ reflect.Type) string {
+	if typ.Name() != "" && typ.PkgPath() != "" {
+		return typ.PkgPath() + "." + typ.Name()
+	}
+
+	return typ.String()
+}
+
 // Context provides a tiny layer for passing data between callbacks
 type Context struct {
 	contextMap map[string]interface{}
 	lock       *sync.RWMutex
+	// parent is looked up for the keys not in contextMap
+	parent *Context
+	// deleted are the tombstones of the keys deleted from a child
+	deleted map[string]struct{}
+	// shared contexts are passed to the children as is
+	shared bool
 }
 
 // NewContext initializes a new Context instance
@@ -18,35 +67,321 @@ func NewContext() *Context {
 	}
 }
 
-// Put stores a value of any type in Context
+// Child returns a copy-on-write child of Context, the child sees the keys
+// of Context, but its own writes are invisible to Context and the siblings.
+// A shared Context returns itself.
+func (c *Context) Child() *Context {
+	if c == nil {
+		return NewContext()
+	}
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if c.shared {
+		return c
+	}
+
+	child := NewContext()
+	child.parent = c
+
+	return child
+}
+
+// Share marks Context as shared, so the requests created from it
+// use the same Context instead of a child, it returns Context itself.
+func (c *Context) Share() *Context {
+	c.lock.Lock()
+	c.shared = true
+	c.lock.Unlock()
+
+	return c
+}
+
+// Put stores a value of any type in Context,
+// the key "@types" is reserved for serialization.
 func (c *Context) Put(key string, value interface{}) {
 	c.lock.Lock()
 	c.contextMap[key] = value
+	delete(c.deleted, key)
 	c.lock.Unlock()
 }
 
-// Get retrieves a string value from Context.
-// Get returns an empty string if key not found
-func (c *Context) Get(key string) string {
+func (c *Context) lookup(key string) (interface{}, bool) {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 
 	if v, ok := c.contextMap[key]; ok {
-		return v.(string)
+		return v, true
 	}
 
-	return ""
+	if _, ok := c.deleted[key]; ok || c.parent == nil {
+		return nil, false
+	}
+
+	return c.parent.lookup(key)
 }
 
-// ForEach iterate context
-func (c *Context) ForEach(fn func(k string, v interface{}) interface{}) []interface{} {
+// flatten returns all visible keys, including the ones of parents.
+func (c *Context) flatten() map[string]interface{} {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 
-	ret := make([]interface{}, 0, len(c.contextMap))
+	var m map[string]interface{}
+	if c.parent != nil {
+		m = c.parent.flatten()
+	} else {
+		m = make(map[string]interface{}, len(c.contextMap))
+	}
+
+	for k := range c.deleted {
+		delete(m, k)
+	}
+
 	for k, v := range c.contextMap {
+		m[k] = v
+	}
+
+	return m
+}
+
+// Get retrieves a string value from Context.
+// Get returns an empty string if key not found or the value is not a string
+func (c *Context) Get(key string) string {
+	v, _ := c.lookup(key)
+	if s, ok := v.(string); ok {
+		return s
+	}
+
+	return ""
+}
+
+// GetAny retrieves a value of any type from Context,
+// GetAny returns nil if key not found
+func (c *Context) GetAny(key string) interface{} {
+	v, _ := c.lookup(key)
+	return v
+}
+
+// GetInt retrieves an int value from Context, numbers of other types are converted.
+// GetInt returns 0 if key not found or the value is not a number
+func (c *Context) GetInt(key string) int {
+	v, _ := GetAs[int](c, key)
+	return v
+}
+
+// GetBool retrieves a bool value from Context.
+// GetBool returns false if key not found or the value is not a bool
+func (c *Context) GetBool(key string) bool {
+	v, _ := GetAs[bool](c, key)
+	return v
+}
+
+// GetAs retrieves a value of type T from Context, numbers are converted
+// between numeric types if the value fits T, e.g. 3.0 to int but not 3.7.
+// The second value is false if key not found or the value is not of type T.
+func GetAs[T any](c *Context, key string) (T, bool) {
+	var zero T
+
+	v := c.GetAny(key)
+	if v == nil {
+		return zero, false
+	}
+
+	if t, ok := v.(T); ok {
+		return t, true
+	}
+
+	rv := reflect.ValueOf(v)
+	typ := reflect.TypeOf(zero)
+
+	if typ != nil && isNumberKind(rv.Kind()) && isNumberKind(typ.Kind()) && fitsNumber(rv, typ) {
+		return rv.Convert(typ).Interface().(T), true
+	}
+
+	return zero, false
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Float64
+}
+
+func isIntKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isFloatKind(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+// fitsNumber returns true if the number v converts to typ without truncation,
+// overflow or sign change.
+func fitsNumber(v reflect.Value, typ reflect.Type) bool {
+	target := reflect.Zero(typ)
+
+	switch k := v.Kind(); {
+	case isFloatKind(typ.Kind()):
+		if isFloatKind(k) {
+			return !target.OverflowFloat(v.Float())
+		}
+
+		return true
+	case isIntKind(k):
+		i := v.Int()
+		if isIntKind(typ.Kind()) {
+			return !target.OverflowInt(i)
+		}
+
+		return i >= 0 && !target.OverflowUint(uint64(i))
+	case isFloatKind(k):
+		f := v.Float()
+		if f != math.Trunc(f) {
+			return false
+		}
+
+		// 2^63 and 2^64 are exact in float64
+		if isIntKind(typ.Kind()) {
+			return f >= -(1<<63) && f < 1<<63 && !target.OverflowInt(int64(f))
+		}
+
+		return f >= 0 && f < 1<<64 && !target.OverflowUint(uint64(f))
+	default:
+		u := v.Uint()
+		if isIntKind(typ.Kind()) {
+			return u <= math.MaxInt64 && !target.OverflowInt(int64(u))
+		}
+
+		return !target.OverflowUint(u)
+	}
+}
+
+// Delete removes key from Context
+func (c *Context) Delete(key string) {
+	c.lock.Lock()
+	delete(c.contextMap, key)
+
+	if c.parent != nil {
+		if c.deleted == nil {
+			c.deleted = make(map[string]struct{})
+		}
+
+		c.deleted[key] = struct{}{}
+	}
+
+	c.lock.Unlock()
+}
+
+// Clone returns a shallow copy of Context, the copy has no parent
+func (c *Context) Clone() *Context {
+	clone := NewContext()
+	clone.contextMap = c.flatten()
+
+	return clone
+}
+
+// ForEach iterate context, including the keys of parents
+func (c *Context) ForEach(fn func(k string, v interface{}) interface{}) []interface{} {
+	m := c.flatten()
+
+	ret := make([]interface{}, 0, len(m))
+	for k, v := range m {
 		ret = append(ret, fn(k, v))
 	}
 
 	return ret
 }
+
+// MarshalJSON encodes Context as a JSON object, the names of registered types
+// are kept in the reserved key "@types".
+func (c *Context) MarshalJSON() ([]byte, error) {
+	m := c.flatten()
+	types := make(map[string]string)
+
+	contextTypesLock.RLock()
+	for k, v := range m {
+		if v == nil {
+			continue
+		}
+
+		if name, ok := contextTypeNames[reflect.TypeOf(v)]; ok {
+			types[k] = name
+		}
+	}
+	contextTypesLock.RUnlock()
+
+	if len(types) > 0 {
+		m[_contextTypesKey] = types
+	}
+
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON decodes Context from the JSON object created by MarshalJSON,
+// the existing values are replaced, and the parent is dropped.
+func (c *Context) UnmarshalJSON(b []byte) error {
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	types := make(map[string]string)
+
+	if t, ok := raw[_contextTypesKey]; ok {
+		if err := json.Unmarshal(t, &types); err != nil {
+			return err
+		}
+
+		delete(raw, _contextTypesKey)
+	}
+
+	m := make(map[string]interface{}, len(raw))
+
+	for k, v := range raw {
+		value, err := decodeContextValue(v, types[k])
+		if err != nil {
+			return err
+		}
+
+		m[k] = value
+	}
+
+	if c.lock == nil {
+		c.lock = &sync.RWMutex{}
+	}
+
+	c.lock.Lock()
+	c.contextMap = m
+	c.parent = nil
+	c.deleted = nil
+	c.lock.Unlock()
+
+	return nil
+}
+
+func decodeContextValue(raw json.RawMessage, name string) (interface{}, error) {
+	var value interface{}
+
+	if name == "" {
+		err := json.Unmarshal(raw, &value)
+		return value, err
+	}
+
+	contextTypesLock.RLock()
+	typ, ok := contextTypes[name]
+	contextTypesLock.RUnlock()
+
+	if !ok {
+		log.Debug().Str("type", name).Msg("context type is not registered")
+
+		err := json.Unmarshal(raw, &value)
+
+		return value, err
+	}
+
+	ptr := reflect.New(typ)
+	if err := json.Unmarshal(raw, ptr.Interface()); err != nil {
+		return nil, err
+	}
+
+	return ptr.Elem().Interface(), nil
+}
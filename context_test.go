@@ -0,0 +1,146 @@
+package roddy
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ContextSuite struct {
+	suite.Suite
+}
+
+func TestContext(t *testing.T) {
+	suite.Run(t, new(ContextSuite))
+}
+
+type contextItem struct {
+	Name  string
+	Price float64
+}
+
+func (s *ContextSuite) Test_00_Getters() {
+	ctx := NewContext()
+	ctx.Put("s", "str")
+	ctx.Put("i", 3)
+	ctx.Put("f", 4.0)
+	ctx.Put("b", true)
+
+	s.Equal("str", ctx.Get("s"))
+	s.Equal("", ctx.Get("i"), "no panic on non-string value")
+	s.Equal("", ctx.Get("missing"))
+
+	s.Equal(3, ctx.GetInt("i"))
+	s.Equal(4, ctx.GetInt("f"))
+	s.Equal(0, ctx.GetInt("s"))
+	s.True(ctx.GetBool("b"))
+	s.False(ctx.GetBool("s"))
+
+	f, ok := GetAs[float64](ctx, "i")
+	s.True(ok)
+	s.Equal(3.0, f)
+
+	_, ok = GetAs[string](ctx, "b")
+	s.False(ok)
+
+	clone := ctx.Clone()
+	clone.Delete("s")
+	s.Nil(clone.GetAny("s"))
+	s.Equal("str", ctx.Get("s"), "clone is independent")
+}
+
+func (s *ContextSuite) Test_01_RoundTrip() {
+	RegisterContextType(contextItem{})
+
+	now := time.Now().Truncate(time.Second)
+
+	ctx := NewContext()
+	ctx.Put("n", 42)
+	ctx.Put("f", 1.5)
+	ctx.Put("when", now)
+	ctx.Put("item", contextItem{Name: "book", Price: 9.9})
+	ctx.Put("tags", []string{"a"})
+
+	u, _ := ParseUrl("http://example.com/")
+	buf, err := (&Request{URL: u, Ctx: ctx}).Marshal()
+	s.Nil(err)
+
+	r, err := NewCollector().UnmarshalRequest(buf)
+	s.Nil(err)
+
+	s.Equal(42, r.Ctx.GetAny("n"))
+	s.Equal(1.5, r.Ctx.GetAny("f"))
+	s.True(now.Equal(r.Ctx.GetAny("when").(time.Time)))
+	s.Equal(contextItem{Name: "book", Price: 9.9}, r.Ctx.GetAny("item"))
+	s.Equal([]interface{}{"a"}, r.Ctx.GetAny("tags"), "unregistered types use JSON defaults")
+}
+
+func (s *ContextSuite) Test_02_Child() {
+	parent := NewContext()
+	parent.Put("category", "books")
+	parent.Put("page", 1)
+
+	a, b := parent.Child(), parent.Child()
+	a.Put("title", "a")
+	b.Put("title", "b")
+	b.Put("page", 2)
+	b.Delete("category")
+
+	s.Equal("books", a.Get("category"), "child sees parent's keys")
+	s.Equal("a", a.Get("title"))
+	s.Equal("b", b.Get("title"), "siblings are independent")
+	s.Equal(1, parent.GetInt("page"), "child writes do not leak to parent")
+	s.Equal("", parent.Get("title"))
+	s.Equal("", b.Get("category"), "deleted key is hidden in child")
+	s.Equal("books", parent.Get("category"))
+
+	grandchild := b.Child()
+	s.Equal(2, grandchild.GetInt("page"))
+	s.Equal("", grandchild.Get("category"))
+
+	s.Equal(map[string]interface{}{"page": 2, "title": "b"}, b.Clone().flatten())
+
+	buf, err := b.MarshalJSON()
+	s.Nil(err)
+	s.JSONEq(`{"page": 2, "title": "b", "@types": {"page": "int"}}`, string(buf))
+
+	shared := NewContext().Share()
+	s.Same(shared, shared.Child())
+
+	var nilCtx *Context
+	s.NotNil(nilCtx.Child())
+}
+
+func (s *ContextSuite) Test_03_GetAsNumbers() {
+	ctx := NewContext()
+
+	tests := []struct {
+		value any
+		get   func() (any, bool)
+		want  any
+		ok    bool
+	}{
+		{3.0, func() (any, bool) { return GetAs[int](ctx, "v") }, 3, true},
+		{3.7, func() (any, bool) { return GetAs[int](ctx, "v") }, 0, false},
+		{-1, func() (any, bool) { return GetAs[uint](ctx, "v") }, uint(0), false},
+		{-1.0, func() (any, bool) { return GetAs[uint64](ctx, "v") }, uint64(0), false},
+		{300, func() (any, bool) { return GetAs[int8](ctx, "v") }, int8(0), false},
+		{-128, func() (any, bool) { return GetAs[int8](ctx, "v") }, int8(-128), true},
+		{uint64(math.MaxUint64), func() (any, bool) { return GetAs[int64](ctx, "v") }, int64(0), false},
+		{uint8(200), func() (any, bool) { return GetAs[int](ctx, "v") }, 200, true},
+		{1e300, func() (any, bool) { return GetAs[int64](ctx, "v") }, int64(0), false},
+		{1e300, func() (any, bool) { return GetAs[float32](ctx, "v") }, float32(0), false},
+		{float64(1 << 53), func() (any, bool) { return GetAs[int64](ctx, "v") }, int64(1 << 53), true},
+		{7, func() (any, bool) { return GetAs[float64](ctx, "v") }, 7.0, true},
+	}
+
+	for _, tt := range tests {
+		ctx.Put("v", tt.value)
+
+		got, ok := tt.get()
+		s.Equal(tt.ok, ok, "%T(%v)", tt.value, tt.value)
+		s.Equal(tt.want, got, "%T(%v)", tt.value, tt.value)
+	}
+}
@@ -0,0 +1,113 @@
+package roddy
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// domainMatcher is a precompiled list of domain patterns:
+//   - "example.com": a registrable domain matches itself and all its subdomains,
+//     e.g. "reddit.com" matches "old.reddit.com"
+//   - "old.example.com": other domains, IPs and single label hosts match exactly
+//   - "*.example.com": matches all subdomains, but not "example.com" itself
+//   - "example.com:8080": a pattern with port only matches the port,
+//     the default port of http(s) is used if the URL has no port.
+//
+// Each lookup costs a few map accesses, regardless of the number of patterns.
+type domainMatcher struct {
+	exact       map[string]struct{}
+	registrable map[string]struct{}
+	wildcard    map[string]struct{}
+}
+
+func newDomainMatcher(patterns []string) *domainMatcher {
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	m := &domainMatcher{
+		exact:       make(map[string]struct{}),
+		registrable: make(map[string]struct{}),
+		wildcard:    make(map[string]struct{}),
+	}
+
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+
+		if rest, ok := strings.CutPrefix(p, "*."); ok {
+			m.wildcard[rest] = struct{}{}
+			continue
+		}
+
+		host := (&url.URL{Host: p}).Hostname()
+		if isRegistrableDomain(host) {
+			m.registrable[p] = struct{}{}
+		} else {
+			m.exact[p] = struct{}{}
+		}
+	}
+
+	return m
+}
+
+// match returns true if the host of u matches any pattern
+func (m *domainMatcher) match(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+
+	if port == "" {
+		switch u.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+	}
+
+	has := func(set map[string]struct{}, h string) bool {
+		if _, ok := set[h]; ok {
+			return true
+		}
+
+		_, ok := set[net.JoinHostPort(h, port)]
+
+		return ok
+	}
+
+	if has(m.exact, host) {
+		return true
+	}
+
+	if net.ParseIP(host) != nil {
+		return false
+	}
+
+	if etld1, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil && has(m.registrable, etld1) {
+		return true
+	}
+
+	for h := host; ; {
+		i := strings.IndexByte(h, '.')
+		if i == -1 {
+			return false
+		}
+
+		h = h[i+1:]
+		if has(m.wildcard, h) {
+			return true
+		}
+	}
+}
+
+func isRegistrableDomain(host string) bool {
+	if net.ParseIP(host) != nil {
+		return false
+	}
+
+	etld1, err := publicsuffix.EffectiveTLDPlusOne(host)
+
+	return err == nil && etld1 == host
+}
@@ -0,0 +1,46 @@
+package roddy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type DomainMatcherSuite struct {
+	suite.Suite
+}
+
+func TestDomainMatcher(t *testing.T) {
+	suite.Run(t, new(DomainMatcherSuite))
+}
+
+func (s *DomainMatcherSuite) Test_00_DomainMatcher() {
+	c := NewCollector(
+		AllowedDomains("reddit.com", "*.example.com", "api.github.com", "127.0.0.1", "localhost:8080", "secure.io:443"),
+		DisallowedDomains("ads.reddit.com"),
+	)
+
+	tests := []struct {
+		uri     string
+		allowed bool
+	}{
+		{"https://reddit.com/r/golang", true},
+		{"https://old.reddit.com/r/golang", true},
+		{"https://www.reddit.com/", true},
+		{"https://ads.reddit.com/", false},
+		{"https://notreddit.com/", false},
+		{"https://a.b.example.com/", true},
+		{"https://example.com/", false},
+		{"https://api.github.com/", true},
+		{"https://github.com/", false},
+		{"http://127.0.0.1:12345/", true},
+		{"http://localhost:8080/", true},
+		{"http://localhost:9090/", false},
+		{"https://secure.io/", true},
+		{"https://www.secure.io:8443/", false},
+	}
+	for _, tt := range tests {
+		u, _ := ParseUrl(tt.uri)
+		s.Equal(tt.allowed, c.isDomainAllowed(u), tt.uri)
+	}
+}
@@ -0,0 +1,208 @@
+package roddy
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// EventType is the type of a lifecycle event
+type EventType string
+
+const (
+	// EventRequestQueued is emitted when a URL passed all checks and waits for fetching
+	EventRequestQueued EventType = "request-queued"
+	// EventRequestStarted is emitted after OnRequest callbacks, right before navigating
+	EventRequestStarted EventType = "request-started"
+	// EventNavigated is emitted when the page is loaded
+	EventNavigated EventType = "navigated"
+	// EventCallbackRun is emitted before an OnHTML/OnPaging/OnData callback runs
+	EventCallbackRun EventType = "callback-run"
+	// EventCallbackError is emitted when a callback returns an error
+	EventCallbackError EventType = "callback-error"
+	// EventSkipped is emitted when a URL is rejected by the request checks
+	EventSkipped EventType = "skipped"
+	// EventRetried is emitted when a failed request is scheduled again
+	EventRetried EventType = "retried"
+	// EventScraped is emitted after OnScraped callbacks
+	EventScraped EventType = "scraped"
+)
+
+// Event is a single entry of the request lifecycle.
+//
+// Fields are only appended, never renamed, so the JSON form can be used
+// by external tooling.
+type Event struct {
+	// Seq is a per-collector monotonic sequence, use it to order events
+	// emitted at the same time.
+	Seq         uint64    `json:"seq"`
+	Time        time.Time `json:"time"`
+	Type        EventType `json:"type"`
+	CollectorID uint32    `json:"collector_id"`
+	RequestID   uint32    `json:"request_id,omitempty"`
+	BotID       string    `json:"bot_id,omitempty"`
+	PageID      string    `json:"page_id,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	Depth       int       `json:"depth"`
+	// Callback is the kind of callback: html, paging or data
+	Callback string `json:"callback,omitempty"`
+	Selector string `json:"selector,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// EventSink receives lifecycle events of a Collector.
+// EventSink must be concurrently safe for multiple goroutines.
+type EventSink interface {
+	// Emit writes the event
+	Emit(ev *Event) error
+	// Close flushes and releases the sink
+	Close() error
+}
+
+// JSONLSink writes each event as one JSON line.
+type JSONLSink struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+// NewJSONLSink creates (or appends to) the JSONL file at path.
+func NewJSONLSink(path string) (*JSONLSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewJSONLWriterSink(f), nil
+}
+
+// NewJSONLWriterSink writes events to w, w is closed by Close if it is an io.Closer.
+func NewJSONLWriterSink(w io.Writer) *JSONLSink {
+	return &JSONLSink{
+		w:   w,
+		enc: json.NewEncoder(w),
+	}
+}
+
+// Emit implements EventSink.Emit()
+func (s *JSONLSink) Emit(ev *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.enc.Encode(ev)
+}
+
+// Close implements EventSink.Close()
+func (s *JSONLSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cl, ok := s.w.(io.Closer); ok {
+		return cl.Close()
+	}
+
+	return nil
+}
+
+// WithEventSink sends the request lifecycle events to sink.
+func WithEventSink(sink EventSink) CollectorOption {
+	return func(c *Collector) {
+		c.eventSink = sink
+	}
+}
+
+func (c *Collector) emit(ev *Event) {
+	if c.eventSink == nil {
+		return
+	}
+
+	ev.Seq = atomic.AddUint64(&c.eventSeq, 1)
+	ev.Time = time.Now()
+	ev.CollectorID = c.ID
+
+	if err := c.eventSink.Emit(ev); err != nil {
+		log.Error().Err(err).Str("event", string(ev.Type)).Msg("cannot emit event")
+	}
+}
+
+// Emit sends a lifecycle event of r to the event sink, err is optional.
+// It's used by the components driving the collector, like queue.
+func (c *Collector) Emit(typ EventType, r *Request, err error) {
+	c.emitRequest(typ, r, err)
+}
+
+// emitURL emits events which has no Request created yet.
+func (c *Collector) emitURL(typ EventType, u string, depth int, err error) {
+	if c.eventSink == nil {
+		return
+	}
+
+	ev := &Event{Type: typ, URL: u, Depth: depth}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+
+	c.emit(ev)
+}
+
+// emitRequest emits events of request, err is optional.
+func (c *Collector) emitRequest(typ EventType, r *Request, err error) {
+	if c.eventSink == nil {
+		return
+	}
+
+	ev := r.event(typ)
+	if err != nil {
+		ev.Error = err.Error()
+	}
+
+	c.emit(ev)
+}
+
+func (c *Collector) emitCallback(typ EventType, r *Request, kind, selector string, err error) {
+	if c.eventSink == nil {
+		return
+	}
+
+	ev := r.event(typ)
+	ev.Callback = kind
+	ev.Selector = selector
+
+	if err != nil {
+		ev.Error = err.Error()
+	}
+
+	c.emit(ev)
+}
+
+func (r *Request) event(typ EventType) *Event {
+	ev := &Event{
+		Type:      typ,
+		RequestID: r.ID,
+		Depth:     r.Depth,
+	}
+
+	if r.URL != nil {
+		ev.URL = r.URL.String()
+	}
+
+	if r.bot != nil {
+		ev.BotID = r.bot.UniqueID
+	}
+
+	if r.page != nil {
+		ev.PageID = string(r.page.TargetID)
+	}
+
+	return ev
+}
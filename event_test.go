@@ -0,0 +1,139 @@
+package roddy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type EventSuite struct {
+	suite.Suite
+	ts *httptest.Server
+}
+
+func TestEvent(t *testing.T) {
+	suite.Run(t, new(EventSuite))
+}
+
+func (s *EventSuite) SetupSuite() {
+	s.ts = newTestServer()
+}
+
+func (s *EventSuite) TearDownSuite() {
+	s.ts.Close()
+}
+
+func (s *EventSuite) events(r *bytes.Buffer) []*Event {
+	var events []*Event
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		ev := &Event{}
+		s.Require().Nil(json.Unmarshal(sc.Bytes(), ev))
+		events = append(events, ev)
+	}
+
+	return events
+}
+
+func (s *EventSuite) Test_00_Sink() {
+	buf := &bytes.Buffer{}
+
+	c := NewCollector(WithEventSink(NewJSONLWriterSink(buf)), DisallowedURLFilters(regexp.MustCompile(`/blocked`)))
+
+	s.ErrorIs(c.Visit(s.ts.URL+"/blocked"), ErrForbiddenURL)
+
+	u, _ := ParseUrl(s.ts.URL + "/retry")
+	c.Emit(EventRetried, &Request{ID: 9, URL: u, Depth: 2}, errors.New("boom"))
+
+	events := s.events(buf)
+	s.Require().Len(events, 2)
+
+	s.Equal(EventSkipped, events[0].Type)
+	s.Equal(s.ts.URL+"/blocked", events[0].URL)
+	s.Zero(events[0].RequestID, "no request id before the checks pass")
+	s.Contains(events[0].Error, ErrForbiddenURL.Error())
+
+	s.Equal(EventRetried, events[1].Type)
+	s.Equal(uint32(9), events[1].RequestID)
+	s.Equal(2, events[1].Depth)
+	s.Equal("boom", events[1].Error)
+
+	s.Equal([]uint64{1, 2}, []uint64{events[0].Seq, events[1].Seq})
+	s.Equal(c.ID, events[1].CollectorID)
+
+	path := filepath.Join(s.T().TempDir(), "events", "events.jsonl")
+
+	sink, err := NewJSONLSink(path)
+	s.Nil(err)
+	s.Nil(sink.Emit(&Event{Type: EventScraped}))
+	s.Nil(sink.Close())
+
+	content, err := os.ReadFile(path)
+	s.Nil(err)
+	s.Len(s.events(bytes.NewBuffer(content)), 1)
+}
+
+func (s *EventSuite) Test_01_Scrape() {
+	buf := &bytes.Buffer{}
+
+	c := NewCollector(WithEventSink(NewJSONLWriterSink(buf)))
+
+	var requestIDs []uint32
+
+	c.OnRequest(func(r *Request) {
+		requestIDs = append(requestIDs, r.ID)
+	})
+	c.OnHTML("title", func(e *SerpElement) error { return nil })
+	c.OnData("p.description", func(e *DataElement) {})
+
+	s.Nil(c.Visit(s.ts.URL + "/html"))
+	s.Nil(c.Visit(s.ts.URL + "/list?page=1"))
+
+	events := s.events(buf)
+
+	var kinds []string
+
+	for _, ev := range events {
+		kind := string(ev.Type)
+		if ev.Callback != "" {
+			kind += ":" + ev.Callback
+		}
+
+		kinds = append(kinds, kind)
+	}
+
+	s.Equal([]string{
+		"request-queued", "request-started", "navigated", "callback-run:html",
+		"callback-run:data", "callback-run:data", "scraped",
+		"request-queued", "request-started", "navigated", "callback-run:html", "scraped",
+	}, kinds)
+
+	// the request ID is assigned when queued, and kept by the fetch
+	s.Equal([]uint32{1, 2}, requestIDs)
+
+	for i, ev := range events {
+		want := uint32(1)
+		if i >= 7 {
+			want = 2
+		}
+
+		s.Equal(want, ev.RequestID, ev.Type)
+		s.Equal(uint64(i+1), ev.Seq)
+
+		if ev.Type != EventRequestQueued {
+			s.NotEmpty(ev.BotID, ev.Type)
+		}
+	}
+
+	s.Equal("p.description", events[4].Selector)
+	s.Equal(s.ts.URL+"/html", events[6].URL)
+}
@@ -60,10 +60,8 @@ func crawl(company string) {
 
 	c.OnHTML(sortByDate, func(e *roddy.SerpElement) error {
 		log.Debug().Msg("toggle sort by date option")
-		err := e.Click(e.Selector)
-		c.OnHTMLDetach(sortByDate)
-		return err
-	})
+		return e.Click(e.Selector)
+	}, roddy.Once())
 
 	c.OnPaging(paginate, func(e *roddy.SerpElement) error {
 		pg := cast.ToInt(e.Bot.GetElementAttr(`div.paginate>strong`))
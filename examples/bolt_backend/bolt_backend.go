@@ -0,0 +1,56 @@
+package main
+
+import (
+	"roddy"
+	"roddy/queue"
+	"roddy/storage/boltstorage"
+
+	"github.com/coghost/xlog"
+	"github.com/k0kubun/pp/v3"
+)
+
+func main() {
+	xlog.InitLogDebug()
+
+	db, err := boltstorage.Open("/tmp/roddy/bolt_backend.db")
+	if err != nil {
+		panic(err)
+	}
+
+	defer db.Close()
+
+	_cap := 2
+
+	c := roddy.NewCollector(
+		roddy.Parallelism(_cap),
+	)
+
+	// visited urls, cookies and the queue share one file
+	storage := db.Storage("httpbin_test")
+
+	if err := c.SetStorage(storage); err != nil {
+		panic(err)
+	}
+
+	if err := storage.Clear(); err != nil {
+		panic(err)
+	}
+
+	q, _ := queue.New(_cap, storage)
+
+	c.OnResponse(func(r *roddy.Response) {
+		pp.Println(r.Request.IDString(), r.Page.MustCookies())
+	})
+
+	urls := []string{
+		"http://httpbin.org/",
+		"http://httpbin.org/ip",
+		"http://httpbin.org/cookies/set?a=b&c=d",
+		"http://httpbin.org/cookies",
+	}
+	for _, u := range urls {
+		q.AddURL(u)
+	}
+
+	q.Run(c)
+}
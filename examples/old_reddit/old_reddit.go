@@ -91,10 +91,10 @@ func runAsync() {
 		stories = append(stories, story)
 	})
 
-	c.OnPaging(`span.next-button`, func(e *roddy.SerpElement) error {
-		fmt.Println("got", len(stories), e.Request.String())
-		e.Click(e.Selector)
-		return e.Request.VisitByMockClick()
+	c.Paginate(roddy.NextButton(`span.next-button>a`), roddy.PageStop{
+		ItemSelector: `div.top-matter`,
+		NoNewItems:   true,
+		MaxPages:     5,
 	})
 
 	c.OnError(func(r *roddy.Response, err error) {
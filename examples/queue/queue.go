@@ -49,5 +49,10 @@ func main() {
 		q.AddURL(fmt.Sprintf("%s?n=%d", url, i))
 	}
 
-	q.Run(c)
+	report, err := q.Run(c)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(report)
 }
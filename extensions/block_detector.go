@@ -0,0 +1,64 @@
+package extensions
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"roddy"
+)
+
+// ErrBlocked is returned when the loaded page is a block page, like captcha or access denied
+var ErrBlocked = errors.New("Blocked page detected")
+
+// DefaultBlockPatterns match the HTML of the common block and challenge pages
+var DefaultBlockPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)<title>\s*(access denied|attention required|just a moment\.\.\.|403 forbidden)`),
+	regexp.MustCompile(`(?i)cf-(chl|challenge)-`),
+	regexp.MustCompile(`(?i)(g-recaptcha|h-captcha|hcaptcha\.com/1/api\.js)`),
+	regexp.MustCompile(`(?i)(unusual traffic from your computer|verify you are (a )?human)`),
+}
+
+// BlockDetector fails the requests whose page matches any pattern with ErrBlocked,
+// so they are handled by OnError and retried by queue. DefaultBlockPatterns are used
+// if no patterns are given.
+func BlockDetector(c *roddy.Collector, patterns ...*regexp.Regexp) {
+	if len(patterns) == 0 {
+		patterns = DefaultBlockPatterns
+	}
+
+	c.Use(blockDetector(patterns))
+}
+
+func blockDetector(patterns []*regexp.Regexp) roddy.Middleware {
+	return func(next roddy.Handler) roddy.Handler {
+		return func(r *roddy.Request) (*roddy.Response, error) {
+			resp, err := next(r)
+			if err != nil || resp == nil || resp.Page == nil {
+				return resp, err
+			}
+
+			html, err := resp.Page.HTML()
+			if err != nil {
+				return nil, err
+			}
+
+			if m := detectBlock(html, patterns); m != "" {
+				return nil, fmt.Errorf("%w: %s matches %q", ErrBlocked, r.URL, m)
+			}
+
+			return resp, nil
+		}
+	}
+}
+
+// detectBlock returns the matched text of the first matched pattern
+func detectBlock(html string, patterns []*regexp.Regexp) string {
+	for _, p := range patterns {
+		if m := p.FindString(html); m != "" {
+			return m
+		}
+	}
+
+	return ""
+}
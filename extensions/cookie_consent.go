@@ -0,0 +1,72 @@
+package extensions
+
+import (
+	"time"
+
+	"roddy"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+	"github.com/rs/zerolog/log"
+)
+
+const _consentClickTimeout = 2 * time.Second
+
+// DefaultConsentSelectors are the accept buttons of the common consent management platforms
+var DefaultConsentSelectors = []string{
+	"#onetrust-accept-btn-handler",
+	"#didomi-notice-agree-button",
+	"#CybotCookiebotDialogBodyLevelButtonLLSelectAll",
+	"#truste-consent-button",
+	".fc-cta-consent",
+	".cc-allow",
+	".cc-dismiss",
+	"button[data-testid='uc-accept-all-button']",
+	"[aria-label='Accept cookies']",
+}
+
+// _consentButtonText matches the text of generic accept buttons
+const _consentButtonText = `/^\s*(accept|agree|allow|i agree|got it|ok)( all)?( cookies)?\s*$/i`
+
+// CookieConsent clicks the accept button of cookie consent banners after page load,
+// before the OnResponse callbacks. DefaultConsentSelectors are used if no selectors are given.
+func CookieConsent(c *roddy.Collector, selectors ...string) {
+	if len(selectors) == 0 {
+		selectors = DefaultConsentSelectors
+	}
+
+	c.Use(cookieConsent(selectors))
+}
+
+func cookieConsent(selectors []string) roddy.Middleware {
+	return func(next roddy.Handler) roddy.Handler {
+		return func(r *roddy.Request) (*roddy.Response, error) {
+			resp, err := next(r)
+			if err != nil || resp == nil || resp.Page == nil {
+				return resp, err
+			}
+
+			if elem := findConsentButton(resp.Page, selectors); elem != nil {
+				if err := elem.Timeout(_consentClickTimeout).Click(proto.InputMouseButtonLeft, 1); err != nil {
+					log.Debug().Err(err).Str("url", r.URL.String()).Msg("cannot dismiss cookie consent")
+				}
+			}
+
+			return resp, nil
+		}
+	}
+}
+
+func findConsentButton(page *rod.Page, selectors []string) *rod.Element {
+	for _, sel := range selectors {
+		if found, elem, err := page.Has(sel); err == nil && found {
+			return elem
+		}
+	}
+
+	if found, elem, err := page.HasR("button", _consentButtonText); err == nil && found {
+		return elem
+	}
+
+	return nil
+}
@@ -0,0 +1,3 @@
+// Package extensions implements various helper extensions for Collector,
+// each extension is enabled by a single call, e.g. extensions.RandomUserAgent(c).
+package extensions
@@ -0,0 +1,224 @@
+package extensions
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"roddy"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ExtensionsSuite struct {
+	suite.Suite
+	ts *httptest.Server
+}
+
+func TestExtensions(t *testing.T) {
+	suite.Run(t, new(ExtensionsSuite))
+}
+
+func (s *ExtensionsSuite) SetupSuite() {
+	mux := http.NewServeMux()
+
+	page := func(w http.ResponseWriter, title, body string) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<!DOCTYPE html><html><head><title>%s</title></head><body>%s</body></html>`, title, body)
+	}
+
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		page(w, "Products", `<p>Are you a robot fan? Buy one.</p>`)
+	})
+
+	mux.HandleFunc("/cloudflare", func(w http.ResponseWriter, r *http.Request) {
+		page(w, "Just a moment...", `<div id="cf-chl-widget"></div>`)
+	})
+
+	mux.HandleFunc("/captcha", func(w http.ResponseWriter, r *http.Request) {
+		page(w, "Search", `<p>Our systems have detected unusual traffic from your computer network.</p>`)
+	})
+
+	// /headers echoes the request headers
+	mux.HandleFunc("/headers", func(w http.ResponseWriter, r *http.Request) {
+		page(w, "Headers", fmt.Sprintf(`<p id="user-agent">%s</p><p id="referer">%s</p>`,
+			html.EscapeString(r.UserAgent()), html.EscapeString(r.Referer())))
+	})
+
+	mux.HandleFunc("/links", func(w http.ResponseWriter, r *http.Request) {
+		page(w, "Links", `<a href="/headers?from=links">Headers</a>`)
+	})
+
+	// /chain?d=N links to /chain?d=N+1, up to 5
+	mux.HandleFunc("/chain", func(w http.ResponseWriter, r *http.Request) {
+		d, _ := strconv.Atoi(r.URL.Query().Get("d"))
+
+		body := ""
+		if d < 5 {
+			body = fmt.Sprintf(`<a href="/chain?d=%d">next</a>`, d+1)
+		}
+
+		page(w, "Chain", body)
+	})
+
+	// the consent buttons replace #state when clicked
+	consent := `<div id="banner">We use cookies %s</div><p id="state">pending</p>
+<script>
+document.querySelector("#banner button").addEventListener("click", () => {
+	document.querySelector("#banner").remove();
+	document.querySelector("#state").textContent = "accepted";
+});
+</script>`
+
+	mux.HandleFunc("/consent", func(w http.ResponseWriter, r *http.Request) {
+		page(w, "Consent", fmt.Sprintf(consent, `<button id="onetrust-accept-btn-handler">Allow all</button>`))
+	})
+
+	mux.HandleFunc("/consent_generic", func(w http.ResponseWriter, r *http.Request) {
+		page(w, "Consent", fmt.Sprintf(consent, `<button class="custom">Accept all cookies</button>`))
+	})
+
+	s.ts = httptest.NewServer(mux)
+}
+
+func (s *ExtensionsSuite) TearDownSuite() {
+	s.ts.Close()
+}
+
+// texts records the text of the elements matching selector
+func texts(c *roddy.Collector, selector string) *[]string {
+	got := &[]string{}
+
+	c.OnData(selector, func(e *roddy.DataElement) {
+		*got = append(*got, strings.TrimSpace(e.Text()))
+	})
+
+	return got
+}
+
+// responses records the paths and queries of the loaded pages
+func responses(c *roddy.Collector) *[]string {
+	got := &[]string{}
+
+	c.OnResponse(func(r *roddy.Response) {
+		*got = append(*got, r.Request.URL.RequestURI())
+	})
+
+	return got
+}
+
+func (s *ExtensionsSuite) Test_00_BlockDetector() {
+	s.Equal("", detectBlock(`<title>Products</title><p>Are you a robot fan?</p>`, DefaultBlockPatterns),
+		"block words in content are not block page")
+
+	c := roddy.NewCollector()
+	BlockDetector(c)
+
+	var errs []error
+
+	c.OnError(func(r *roddy.Response, err error) { errs = append(errs, err) })
+	loaded := responses(c)
+
+	s.Nil(c.Visit(s.ts.URL + "/ok"))
+
+	err := c.Visit(s.ts.URL + "/cloudflare")
+	s.ErrorIs(err, ErrBlocked)
+	s.ErrorContains(err, "Just a moment...")
+
+	err = c.Visit(s.ts.URL + "/captcha")
+	s.ErrorIs(err, ErrBlocked)
+	s.ErrorContains(err, "unusual traffic from your computer")
+
+	s.Equal([]string{"/ok"}, *loaded, "blocked pages don't run callbacks")
+	s.Len(errs, 2)
+
+	// custom patterns replace the default ones
+	c = roddy.NewCollector()
+	BlockDetector(c, regexp.MustCompile(`robot fan`))
+
+	s.ErrorIs(c.Visit(s.ts.URL+"/ok"), ErrBlocked)
+	s.Nil(c.Visit(s.ts.URL + "/captcha"))
+}
+
+func (s *ExtensionsSuite) Test_01_Filters() {
+	c := roddy.NewCollector()
+	DepthFilter(c, 1, 2)
+	URLLengthFilter(c, len(s.ts.URL+"/chain?d=1"))
+
+	c.FollowLinks(roddy.Rule{Allow: regexp.MustCompile(`/chain`)})
+	loaded := responses(c)
+
+	s.Nil(c.Visit(s.ts.URL + "/chain?d=1"))
+	s.Nil(c.Visit(s.ts.URL+"/chain?d=100"), "dropped quietly")
+	s.Equal([]string{"/chain?d=1", "/chain?d=2"}, *loaded, "depth 3 is dropped")
+
+	c = roddy.NewCollector()
+	DepthFilter(c, 2, 0)
+	c.FollowLinks(roddy.Rule{Allow: regexp.MustCompile(`/chain`)})
+	loaded = responses(c)
+
+	s.Nil(c.Visit(s.ts.URL + "/chain?d=4"))
+	s.Empty(*loaded, "depth 1 is dropped, so nothing is followed")
+}
+
+func (s *ExtensionsSuite) Test_02_Referer() {
+	c := roddy.NewCollector()
+	Referer(c)
+
+	c.FollowLinks(roddy.Rule{Allow: regexp.MustCompile(`/headers`)})
+	referers := texts(c, "#referer")
+
+	s.Nil(c.Visit(s.ts.URL + "/headers"))
+	s.Nil(c.Visit(s.ts.URL + "/links"))
+	s.Nil(c.Visit(s.ts.URL + "/headers?direct=1"))
+
+	// only the followed request has the referer, the header doesn't leak to the next ones
+	s.Equal([]string{"", s.ts.URL + "/links", ""}, *referers)
+}
+
+func (s *ExtensionsSuite) Test_03_RandomUserAgent() {
+	c := roddy.NewCollector()
+	RandomUserAgent(c)
+
+	agents := texts(c, "#user-agent")
+
+	for i := 0; i < 5; i++ {
+		s.Nil(c.Visit(fmt.Sprintf("%s/headers?i=%d", s.ts.URL, i)))
+	}
+
+	s.Len(*agents, 5)
+
+	for _, ua := range *agents {
+		s.Contains(_userAgents, ua)
+	}
+}
+
+func (s *ExtensionsSuite) Test_04_CookieConsent() {
+	c := roddy.NewCollector()
+	CookieConsent(c)
+
+	states := texts(c, "#state")
+	banners := texts(c, "#banner")
+
+	s.Nil(c.Visit(s.ts.URL + "/consent"))
+	s.Nil(c.Visit(s.ts.URL + "/consent_generic"))
+	s.Nil(c.Visit(s.ts.URL+"/ok"), "no banner")
+
+	s.Equal([]string{"accepted", "accepted"}, *states, "dismissed before the callbacks")
+	s.Empty(*banners)
+
+	// custom selectors replace the default ones, the button text still works
+	c = roddy.NewCollector()
+	CookieConsent(c, "#not-found")
+
+	states = texts(c, "#state")
+
+	s.Nil(c.Visit(s.ts.URL + "/consent"))
+	s.Nil(c.Visit(s.ts.URL + "/consent_generic"))
+	s.Equal([]string{"pending", "accepted"}, *states)
+}
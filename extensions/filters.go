@@ -0,0 +1,35 @@
+package extensions
+
+import (
+	"roddy"
+
+	"github.com/rs/zerolog/log"
+)
+
+// URLLengthFilter drops the requests whose URL is longer than URLLengthLimit.
+func URLLengthFilter(c *roddy.Collector, URLLengthLimit int) {
+	c.Use(dropIf(func(r *roddy.Request) bool {
+		return r.URL != nil && len(r.URL.String()) > URLLengthLimit
+	}, "url too long"))
+}
+
+// DepthFilter drops the requests whose depth is out of [minDepth, maxDepth],
+// set maxDepth to 0 for no upper limit.
+func DepthFilter(c *roddy.Collector, minDepth, maxDepth int) {
+	c.Use(dropIf(func(r *roddy.Request) bool {
+		return r.Depth < minDepth || (maxDepth > 0 && r.Depth > maxDepth)
+	}, "depth out of range"))
+}
+
+func dropIf(drop func(r *roddy.Request) bool, reason string) roddy.Middleware {
+	return func(next roddy.Handler) roddy.Handler {
+		return func(r *roddy.Request) (*roddy.Response, error) {
+			if drop(r) {
+				log.Debug().Str("reason", reason).Int("depth", r.Depth).Msg("drop request")
+				return nil, nil
+			}
+
+			return next(r)
+		}
+	}
+}
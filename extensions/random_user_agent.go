@@ -0,0 +1,41 @@
+package extensions
+
+import (
+	"math/rand"
+
+	"roddy"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+var _userAgents = []string{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15",
+	"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:122.0) Gecko/20100101 Firefox/122.0",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 14.2; rv:122.0) Gecko/20100101 Firefox/122.0",
+	"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:122.0) Gecko/20100101 Firefox/122.0",
+}
+
+// RandomUserAgent sets a random User-Agent of the common desktop browsers for each request.
+func RandomUserAgent(c *roddy.Collector) {
+	c.Use(randomUserAgent(func() string {
+		return _userAgents[rand.Intn(len(_userAgents))]
+	}))
+}
+
+func randomUserAgent(gen func() string) roddy.Middleware {
+	return func(next roddy.Handler) roddy.Handler {
+		return func(r *roddy.Request) (*roddy.Response, error) {
+			if page := r.Page(); page != nil {
+				if err := page.SetUserAgent(&proto.NetworkSetUserAgentOverride{UserAgent: gen()}); err != nil {
+					return nil, err
+				}
+			}
+
+			return next(r)
+		}
+	}
+}
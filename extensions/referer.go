@@ -0,0 +1,53 @@
+package extensions
+
+import (
+	"roddy"
+)
+
+// _refererKey is the same context key as colly's Referer extension
+const _refererKey = "_referer"
+
+// Referer sets the Referer header of the requests created in callbacks
+// by Request.Visit or Request.New, to the URL of the page they were found on.
+func Referer(c *roddy.Collector) {
+	c.OnResponse(func(r *roddy.Response) {
+		r.Ctx.Put(_refererKey, r.Request.URL.String())
+	})
+
+	c.Use(referer)
+}
+
+func referer(next roddy.Handler) roddy.Handler {
+	return func(r *roddy.Request) (*roddy.Response, error) {
+		page := r.Page()
+
+		ref := refererOf(r)
+		if ref == "" || page == nil {
+			return next(r)
+		}
+
+		cleanup, err := page.SetExtraHeaders([]string{"Referer", ref})
+		if err != nil {
+			return nil, err
+		}
+
+		// the page is reused by other requests
+		defer cleanup()
+
+		return next(r)
+	}
+}
+
+// refererOf returns the URL of the page r was found on
+func refererOf(r *roddy.Request) string {
+	if r.Ctx == nil {
+		return ""
+	}
+
+	ref := r.Ctx.Get(_refererKey)
+	if r.URL != nil && ref == r.URL.String() {
+		return ""
+	}
+
+	return ref
+}
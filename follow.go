@@ -0,0 +1,197 @@
+package roddy
+
+import (
+	"bytes"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Ctx keys of the rule callback of a followed request, the depth is kept to tell
+	// the followed request from its children, which inherit the Ctx.
+	_ruleCallbackKey      = "@callback"
+	_ruleCallbackDepthKey = "@callback_depth"
+
+	_linkSelector = "a[href], area[href]"
+)
+
+// Rule describes the links followed by FollowLinks.
+type Rule struct {
+	// Allow matches the links to follow, all links are allowed if nil
+	Allow *regexp.Regexp
+	// Deny matches the links not to follow, it's checked before Allow
+	Deny *regexp.Regexp
+	// RestrictSelector limits the links to the ones inside the matched elements, the whole page if empty
+	RestrictSelector string
+	// Callback is the name of callbacks run on the followed pages, see ForCallback
+	Callback string
+	// MaxDepth stops following links on pages of depth >= MaxDepth, 0 means no limit
+	MaxDepth int
+}
+
+type ruleContainer struct {
+	Rule   *Rule
+	Filter *callbackFilter
+}
+
+// FollowLinks follows the links of loaded pages matching rule, as
+//
+//	c.OnHTML("a[href]", func(e) { e.Request.Visit(e.Request.AbsoluteURL(e.Link())) })
+//
+// does, but links are extracted from the page HTML instead of live elements, so it's done
+// in one pass without clicking or waiting. Links are canonicalized by AbsoluteURL, and each
+// link is followed once per page, by the first matched rule in priority order.
+// ForURL, ForDepth, Once and Priority options restrict the pages the rule applies to.
+func (c *Collector) FollowLinks(rule Rule, opts ...CallbackOptionFunc) *CallbackHandle {
+	opt := newCallbackOptions(opts...)
+
+	return c.followRules.add(&ruleContainer{
+		Rule:   &rule,
+		Filter: newCallbackFilter(opt),
+	}, opt.priority)
+}
+
+// followedBy returns the rule callback name of r, empty if r is not followed by a rule
+func followedBy(r *Request) string {
+	if r.Ctx == nil {
+		return ""
+	}
+
+	depth, ok := GetAs[int](r.Ctx, _ruleCallbackDepthKey)
+	if !ok || depth != r.Depth {
+		return ""
+	}
+
+	return r.Ctx.Get(_ruleCallbackKey)
+}
+
+// followedLink is a link to follow, and the rule it matched
+type followedLink struct {
+	URL  string
+	Rule *Rule
+}
+
+func (c *Collector) handleFollowLinks(resp *Response) error {
+	rules := c.followRules.snapshot()
+	if len(rules) == 0 {
+		return nil
+	}
+
+	var applied []*Rule
+
+	for _, entry := range rules {
+		rc := entry.value
+		if entry.detached.Load() || (rc.Rule.MaxDepth > 0 && resp.Request.Depth >= rc.Rule.MaxDepth) {
+			continue
+		}
+
+		if !rc.Filter.applies(resp.Request) || !rc.Filter.fire() {
+			continue
+		}
+
+		applied = append(applied, rc.Rule)
+	}
+
+	if len(applied) == 0 {
+		return nil
+	}
+
+	html, err := resp.Page.HTML()
+	if err != nil {
+		return err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewBufferString(html))
+	if err != nil {
+		return err
+	}
+
+	links := extractLinks(doc, resp.Request, applied)
+
+	log.Debug().Str("request", resp.Request.URL.String()).Int("links", len(links)).Msg("follow links")
+
+	// errors of followed pages are reported by their own OnError, they don't fail the parent page
+	for _, l := range links {
+		if err := c.scrape(l.URL, resp.Request.Depth+1, followContext(resp.Request, l.Rule)); err != nil && !IsSkipError(err) {
+			log.Error().Err(err).Str("url", l.URL).Str("request", resp.Request.URL.String()).Msg("cannot follow link")
+		}
+	}
+
+	return nil
+}
+
+// extractLinks returns the distinct links of doc matching rules, in document order.
+func extractLinks(doc *goquery.Document, r *Request, rules []*Rule) []*followedLink {
+	setBaseURL(doc, r)
+
+	var links []*followedLink
+
+	seen := make(map[string]bool)
+
+	for _, rule := range rules {
+		scope := doc.Selection
+		if rule.RestrictSelector != "" {
+			scope = doc.Find(rule.RestrictSelector)
+		}
+
+		scope.Find(_linkSelector).Each(func(_ int, s *goquery.Selection) {
+			href, _ := s.Attr("href")
+
+			u := r.AbsoluteURL(strings.TrimSpace(href))
+			if u == "" || seen[u] || !rule.matches(u) {
+				return
+			}
+
+			seen[u] = true
+			links = append(links, &followedLink{URL: u, Rule: rule})
+		})
+	}
+
+	return links
+}
+
+func (rule *Rule) matches(u string) bool {
+	if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		return false
+	}
+
+	if rule.Deny != nil && rule.Deny.MatchString(u) {
+		return false
+	}
+
+	return rule.Allow == nil || rule.Allow.MatchString(u)
+}
+
+// followContext returns the Ctx of the request followed from r by rule
+func followContext(r *Request, rule *Rule) *Context {
+	ctx := r.Ctx.Child()
+
+	if rule.Callback != "" {
+		ctx.Put(_ruleCallbackKey, rule.Callback)
+		ctx.Put(_ruleCallbackDepthKey, r.Depth+1)
+	}
+
+	return ctx
+}
+
+// setBaseURL sets the base URL of r if doc has a <base href>
+func setBaseURL(doc *goquery.Document, r *Request) {
+	href, found := doc.Find("base[href]").Attr("href")
+	if !found {
+		return
+	}
+
+	u, err := urlParser.ParseRef(r.URL.String(), href)
+	if err != nil {
+		return
+	}
+
+	baseURL, err := url.Parse(u.Href(false))
+	if err == nil {
+		r.baseURL = baseURL
+	}
+}
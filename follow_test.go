@@ -0,0 +1,69 @@
+package roddy
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/suite"
+)
+
+type FollowSuite struct {
+	suite.Suite
+}
+
+func TestFollow(t *testing.T) {
+	suite.Run(t, new(FollowSuite))
+}
+
+func (s *FollowSuite) Test_00_FollowLinks() {
+	html := `<html><head><base href="/shop/"></head><body>
+<nav><a href="/about">About</a><a href="category/books">Books</a></nav>
+<div class="list">
+	<a href="item/1">Item 1</a>
+	<a href=" item/2 ">Item 2</a>
+	<a href="item/1#reviews">Item 1 reviews</a>
+	<a href="/logout">Logout</a>
+	<a href="mailto:shop@example.com">Mail</a>
+	<map><area href="item/3"></map>
+</div>
+</body></html>`
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	s.Require().Nil(err)
+
+	c := NewCollector(WithCanonicalizer(NewCanonicalizer()))
+	u, _ := ParseUrl("https://example.com/shop/index.html")
+	r := &Request{URL: u, Depth: 1, Ctx: NewContext(), collector: c}
+
+	items := &Rule{RestrictSelector: "div.list", Deny: regexp.MustCompile(`/logout`), Callback: "item"}
+	pages := &Rule{Allow: regexp.MustCompile(`/category/|/item/`)}
+
+	var got []string
+	for _, l := range extractLinks(doc, r, []*Rule{items, pages}) {
+		got = append(got, l.URL+" "+l.Rule.Callback)
+	}
+
+	s.Equal([]string{
+		"https://example.com/shop/item/1 item",
+		"https://example.com/shop/item/2 item",
+		"https://example.com/shop/item/3 item",
+		"https://example.com/shop/category/books ",
+	}, got)
+
+	// the named callback applies to the followed page, but not its children
+	filter := newCallbackFilter(newCallbackOptions(ForCallback("item")))
+
+	followed := &Request{URL: u, Depth: 2, Ctx: followContext(r, items)}
+	s.True(filter.applies(followed))
+	s.False(filter.applies(&Request{URL: u, Depth: 3, Ctx: followed.Ctx.Child()}))
+	s.False(filter.applies(&Request{URL: u, Depth: 2, Ctx: followContext(r, pages)}))
+
+	buf, err := followed.Marshal()
+	s.Nil(err)
+
+	restored, err := c.UnmarshalRequest(buf)
+	s.Nil(err)
+	s.True(filter.applies(restored), "callback name survives queue")
+}
@@ -6,6 +6,7 @@ toolchain go1.21.6
 
 require (
 	github.com/PuerkitoBio/goquery v1.8.1
+	github.com/bits-and-blooms/bloom/v3 v3.7.0
 	github.com/coghost/xbot v0.0.0-20231025144912-b691b52c8def
 	github.com/coghost/xdtm v0.1.2-20240109
 	github.com/coghost/xlog v0.0.0-20221026034900-066c4ea5110e
@@ -22,6 +23,7 @@ require (
 	github.com/spf13/cast v1.6.0
 	github.com/stretchr/testify v1.8.4
 	github.com/ungerik/go-dry v0.0.0-20231011182423-d9a07fd18c5f
+	go.etcd.io/bbolt v1.3.9
 	golang.org/x/net v0.21.0
 )
 
@@ -96,6 +98,7 @@ require (
 	github.com/tj/go-naturaldate v1.3.0 // indirect
 	github.com/tklauser/go-sysconf v0.3.13 // indirect
 	github.com/tklauser/numcpus v0.7.0 // indirect
+	github.com/twmb/murmur3 v1.1.6 // indirect
 	github.com/wasilibs/go-re2 v1.4.1 // indirect
 	github.com/xo/terminfo v0.0.0-20220910002029-abceb7e1c41e // indirect
 	github.com/ysmood/fetchup v0.2.4 // indirect
@@ -0,0 +1,55 @@
+package roddy
+
+import (
+	"github.com/coghost/xbot"
+	"github.com/go-rod/rod"
+)
+
+// Handler loads the page of a request and returns the response
+type Handler func(r *Request) (*Response, error)
+
+// Middleware wraps the Handler of fetching, it can change the request before
+// calling next, retry next, or short-circuit without calling next, e.g. return
+// a response loaded from cache. Return a nil response and nil error to drop
+// the request quietly. A response without Page only runs OnResponse and OnScraped,
+// the stages reading the page, like OnHTML, OnData, FollowLinks and Paginate, are skipped.
+type Middleware func(next Handler) Handler
+
+// Use adds middlewares wrapping the fetch of each request, the first added
+// middleware is the outermost one. Use must be called before visiting.
+func (c *Collector) Use(mws ...Middleware) {
+	c.middlewares = append(c.middlewares, mws...)
+}
+
+// handler returns the fetch handler wrapped by the middlewares
+func (c *Collector) handler() Handler {
+	return chainMiddlewares(c.fetchHandler, c.middlewares)
+}
+
+// fetchHandler navigates the page of r to r.URL
+func (c *Collector) fetchHandler(r *Request) (*Response, error) {
+	return c.MustGet(r, r.page, r.URL, r.Depth)
+}
+
+func chainMiddlewares(h Handler, mws []Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+
+	return h
+}
+
+// Page returns the page loading the request
+func (r *Request) Page() *rod.Page {
+	return r.page
+}
+
+// Bot returns the bot owning the page of the request
+func (r *Request) Bot() *xbot.Bot {
+	return r.bot
+}
+
+// Collector returns the collector of the request
+func (r *Request) Collector() *Collector {
+	return r.collector
+}
@@ -0,0 +1,137 @@
+package roddy
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MiddlewareSuite struct {
+	suite.Suite
+	ts *httptest.Server
+}
+
+func TestMiddleware(t *testing.T) {
+	suite.Run(t, new(MiddlewareSuite))
+}
+
+func (s *MiddlewareSuite) SetupSuite() {
+	s.ts = newTestServer()
+}
+
+func (s *MiddlewareSuite) TearDownSuite() {
+	s.ts.Close()
+}
+
+func (s *MiddlewareSuite) Test_00_Middleware() {
+	var trace []string
+
+	tag := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(r *Request) (*Response, error) {
+				trace = append(trace, name+">")
+				resp, err := next(r)
+				trace = append(trace, "<"+name)
+
+				return resp, err
+			}
+		}
+	}
+
+	attempts := 0
+	final := func(r *Request) (*Response, error) {
+		attempts++
+		trace = append(trace, "fetch "+r.URL.Path)
+
+		if attempts < 2 {
+			return nil, ErrNoElemFound
+		}
+
+		return &Response{Request: r}, nil
+	}
+
+	retry := func(next Handler) Handler {
+		return func(r *Request) (*Response, error) {
+			resp, err := next(r)
+			if err != nil {
+				return next(r)
+			}
+
+			return resp, err
+		}
+	}
+
+	rewrite := func(next Handler) Handler {
+		return func(r *Request) (*Response, error) {
+			r.URL.Path = "/rewritten"
+			return next(r)
+		}
+	}
+
+	u, _ := ParseUrl("https://example.com/origin")
+	resp, err := chainMiddlewares(final, []Middleware{tag("a"), retry, rewrite})(&Request{URL: u})
+	s.Nil(err)
+	s.NotNil(resp)
+	s.Equal([]string{"a>", "fetch /rewritten", "fetch /rewritten", "<a"}, trace)
+
+	cached := &Response{}
+	cache := func(next Handler) Handler {
+		return func(r *Request) (*Response, error) {
+			return cached, nil
+		}
+	}
+
+	trace = nil
+	resp, err = chainMiddlewares(final, []Middleware{cache, tag("b")})(&Request{URL: u})
+	s.Nil(err)
+	s.Same(cached, resp)
+	s.Empty(trace, "short-circuited")
+}
+
+func (s *MiddlewareSuite) Test_01_ResponseWithoutPage() {
+	cz := NewCanonicalizer()
+	cz.RelCanonical = true
+
+	c := NewCollector(WithCanonicalizer(cz), DedupContent(DedupExact))
+
+	c.Use(func(next Handler) Handler {
+		return func(r *Request) (*Response, error) {
+			return &Response{Request: r}, nil
+		}
+	})
+
+	var trace []string
+
+	c.OnResponse(func(r *Response) { trace = append(trace, "response") })
+	c.OnHTML("title", func(e *SerpElement) error {
+		trace = append(trace, "html")
+		return nil
+	})
+	c.OnData("title", func(e *DataElement) { trace = append(trace, "data") })
+	c.FollowLinks(Rule{})
+	c.Paginate(NextLink("a"), PageStop{MaxPages: 2})
+	c.OnScraped(func(r *Response) { trace = append(trace, "scraped") })
+
+	s.Nil(c.Visit(s.ts.URL + "/html"))
+	s.Equal([]string{"response", "scraped"}, trace, "page stages are skipped")
+
+	// the response without Request gets the request of fetch
+	buf := &bytes.Buffer{}
+	c = NewCollector(WithEventSink(NewJSONLWriterSink(buf)))
+
+	c.Use(func(next Handler) Handler {
+		return func(r *Request) (*Response, error) {
+			return &Response{}, nil
+		}
+	})
+
+	var scraped string
+
+	c.OnScraped(func(r *Response) { scraped = r.Request.URL.Path })
+
+	s.Nil(c.Visit(s.ts.URL + "/html"))
+	s.Equal("/html", scraped)
+	s.Contains(buf.String(), `"scraped"`)
+}
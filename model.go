@@ -1,6 +1,8 @@
 package roddy
 
 import (
+	"time"
+
 	"github.com/go-rod/rod"
 )
 
@@ -27,9 +29,15 @@ type htmlCallbackContainer struct {
 	Function HTMLCallback
 
 	DeferFunc func(p *rod.Page)
+	Filter    *callbackFilter
+	// Optional skips the callback if Selector is not found
+	Optional bool
+	// WaitFor is the timeout of waiting Selector to appear
+	WaitFor time.Duration
 }
 
 type dataCallbackContainer struct {
 	Selector string
 	Function DataCallback
+	Filter   *callbackFilter
 }
@@ -0,0 +1,597 @@
+package roddy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	_defaultWaitChange = 10 * time.Second
+)
+
+// ErrNoNextPage is returned by PaginationStrategy when the page is the last one
+var ErrNoNextPage = errors.New("No next page")
+
+// PageStop are the stop conditions of pagination, pagination stops when any
+// condition is met, or the strategy finds no next page.
+type PageStop struct {
+	// ItemSelector selects the items of a page, it's required by NoNewItems,
+	// and the page fingerprint only covers items if it's set
+	ItemSelector string
+	// NoNewItems stops if the next page has no unseen items, the page is skipped
+	NoNewItems bool
+	// MaxPages stops after MaxPages pages, 0 means no limit
+	MaxPages int
+	// RepeatedPage stops if the next page has the fingerprint of a seen page, the page is skipped
+	RepeatedPage bool
+	// WaitChange is the timeout of waiting the page to change after click or scroll, 10s by default
+	WaitChange time.Duration
+}
+
+// PaginationStrategy turns a page to its next page, see Paginate.
+type PaginationStrategy interface {
+	// Next turns the page of pg to the next page. It returns the URL of the next page to visit,
+	// or "" if the next page is loaded into the current page. ErrNoNextPage stops pagination.
+	Next(pg *Pager) (string, error)
+	// Appends returns true if the next page is appended to the current page, as load more
+	// and infinite scroll do, then all pages are loaded before callbacks run.
+	Appends() bool
+}
+
+// Pager is the page being turned by a PaginationStrategy
+type Pager struct {
+	Response *Response
+	// Page is the number of the current page, starting from 1
+	Page int
+
+	itemSelector string
+	timeout      time.Duration
+}
+
+// WaitChange runs action, then waits until the page content changes,
+// it returns false if the content is not changed in PageStop.WaitChange.
+func (pg *Pager) WaitChange(action func() error) (bool, error) {
+	before, err := pg.snapshot()
+	if err != nil {
+		return false, err
+	}
+
+	if err := action(); err != nil {
+		return false, err
+	}
+
+	deadline := time.Now().Add(pg.timeout)
+
+	for {
+		after, err := pg.snapshot()
+		if err != nil {
+			return false, err
+		}
+
+		if after.fingerprint != before.fingerprint {
+			return true, nil
+		}
+
+		if !time.Now().Before(deadline) {
+			return false, nil
+		}
+
+		time.Sleep(min(_selectorPollInterval, time.Until(deadline)))
+	}
+}
+
+func (pg *Pager) snapshot() (*pageSnapshot, error) {
+	html, err := pg.Response.Page.HTML()
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshotHTML(html, pg.itemSelector)
+}
+
+// NextLink visits the href of the first element matching selector,
+// the link is read from the page HTML.
+func NextLink(selector string) PaginationStrategy {
+	return &nextLink{selector: selector}
+}
+
+// NextButton clicks the first element matching selector, and waits for the page to change,
+// it's done when the button is absent, disabled or the page is not changed.
+func NextButton(selector string) PaginationStrategy {
+	return &clickNext{selector: selector}
+}
+
+// LoadMore clicks the first element matching selector to load more items into the page,
+// until the button is absent, disabled or nothing is loaded.
+func LoadMore(selector string) PaginationStrategy {
+	return &clickNext{selector: selector, appends: true}
+}
+
+// PageURL visits the URL of the next page number, format has a single %d verb,
+// e.g. "https://example.com/list?page=%d". The first page is visited by the caller.
+// There is always a next URL, so Paginate turns on RepeatedPage, and NoNewItems if
+// ItemSelector is set, pagination stops at the first page without new content.
+func PageURL(format string) PaginationStrategy {
+	return &pageURL{format: format}
+}
+
+// InfiniteScroll scrolls to the bottom of the page to load more items, until nothing is loaded.
+func InfiniteScroll() PaginationStrategy {
+	return &infiniteScroll{}
+}
+
+type nextLink struct {
+	selector string
+}
+
+func (s *nextLink) Next(pg *Pager) (string, error) {
+	html, err := pg.Response.Page.HTML()
+	if err != nil {
+		return "", err
+	}
+
+	return nextLinkOf(html, pg.Response.Request, s.selector)
+}
+
+func (s *nextLink) Appends() bool { return false }
+
+type clickNext struct {
+	selector string
+	appends  bool
+}
+
+func (s *clickNext) Next(pg *Pager) (string, error) {
+	has, el, err := pg.Response.Page.Has(s.selector)
+	if err != nil {
+		return "", err
+	}
+
+	if !has {
+		return "", ErrNoNextPage
+	}
+
+	if disabled, err := el.Attribute("disabled"); err != nil || disabled != nil {
+		return "", ErrNoNextPage
+	}
+
+	changed, err := pg.WaitChange(func() error {
+		return clickElement(el)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if !changed {
+		return "", ErrNoNextPage
+	}
+
+	return "", nil
+}
+
+func (s *clickNext) Appends() bool { return s.appends }
+
+type pageURL struct {
+	format string
+}
+
+func (s *pageURL) Next(pg *Pager) (string, error) {
+	return fmt.Sprintf(s.format, pg.Page+1), nil
+}
+
+func (s *pageURL) Appends() bool { return false }
+
+type infiniteScroll struct{}
+
+func (s *infiniteScroll) Next(pg *Pager) (string, error) {
+	changed, err := pg.WaitChange(func() error {
+		_, err := pg.Response.Page.Eval(`() => window.scrollTo(0, document.body.scrollHeight)`)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if !changed {
+		return "", ErrNoNextPage
+	}
+
+	return "", nil
+}
+
+func (s *infiniteScroll) Appends() bool { return true }
+
+func clickElement(el *rod.Element) error {
+	if err := el.ScrollIntoView(); err != nil {
+		return err
+	}
+
+	return el.Click(proto.InputMouseButtonLeft, 1)
+}
+
+// nextLinkOf returns the absolute href of the first element matching selector in html
+func nextLinkOf(html string, r *Request, selector string) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewBufferString(html))
+	if err != nil {
+		return "", err
+	}
+
+	setBaseURL(doc, r)
+
+	href, ok := doc.Find(selector).First().Attr("href")
+	if !ok {
+		return "", ErrNoNextPage
+	}
+
+	u := r.AbsoluteURL(href)
+	if u == "" {
+		return "", ErrNoNextPage
+	}
+
+	return u, nil
+}
+
+// Paginate turns the loaded pages with strategy until stop. The turned pages run all callbacks
+// as the first page, and the page number is tracked by UpdatePageNum, so MaxPageNum works.
+// ForURL, ForDepth, Once and Priority options restrict the first pages the strategy applies to.
+//
+// The state of stop conditions is kept in memory, so NoNewItems and RepeatedPage only cover
+// the pages turned by the same collector.
+func (c *Collector) Paginate(strategy PaginationStrategy, stop PageStop, opts ...CallbackOptionFunc) *CallbackHandle {
+	opt := newCallbackOptions(opts...)
+
+	if stop.WaitChange == 0 {
+		stop.WaitChange = _defaultWaitChange
+	}
+
+	if _, ok := strategy.(*pageURL); ok {
+		stop.RepeatedPage = true
+		stop.NoNewItems = stop.NoNewItems || stop.ItemSelector != ""
+	}
+
+	return c.paginators.add(&paginatorContainer{
+		Strategy: strategy,
+		Stop:     stop,
+		Filter:   newCallbackFilter(opt),
+		states:   make(map[uint32]*pageState),
+	}, opt.priority)
+}
+
+type paginatorContainer struct {
+	Strategy PaginationStrategy
+	Stop     PageStop
+	Filter   *callbackFilter
+
+	mu sync.Mutex
+	// states are the seen pages of each pagination chain
+	states map[uint32]*pageState
+}
+
+// state returns the state of chain, it's created if create is true
+func (p *paginatorContainer) state(chain uint32, create bool) *pageState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.states[chain]
+	if !ok && create {
+		s = newPageState()
+		p.states[chain] = s
+	}
+
+	return s
+}
+
+func (p *paginatorContainer) end(chain uint32, reason string) {
+	p.mu.Lock()
+	delete(p.states, chain)
+	p.mu.Unlock()
+
+	log.Debug().Uint32("chain", chain).Str("reason", reason).Msg("pagination stopped")
+}
+
+func (p *paginatorContainer) pager(resp *Response, page int) *Pager {
+	return &Pager{
+		Response:     resp,
+		Page:         page,
+		itemSelector: p.Stop.ItemSelector,
+		timeout:      p.Stop.WaitChange,
+	}
+}
+
+// pageSnapshot is the content of a page for stop conditions
+type pageSnapshot struct {
+	fingerprint uint64
+	items       []uint64
+}
+
+// snapshotHTML fingerprints the items of html, or the text of html if itemSelector is empty
+func snapshotHTML(html, itemSelector string) (*pageSnapshot, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewBufferString(html))
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &pageSnapshot{}
+	h := fnv.New64a()
+
+	if itemSelector == "" {
+		io.WriteString(h, doc.Find("body").Text())
+		snap.fingerprint = h.Sum64()
+
+		return snap, nil
+	}
+
+	doc.Find(itemSelector).Each(func(_ int, s *goquery.Selection) {
+		item, err := goquery.OuterHtml(s)
+		if err != nil {
+			return
+		}
+
+		ih := fnv.New64a()
+		io.WriteString(ih, item)
+		snap.items = append(snap.items, ih.Sum64())
+
+		io.WriteString(h, item)
+	})
+
+	snap.fingerprint = h.Sum64()
+
+	return snap, nil
+}
+
+// pageState holds the seen pages of a pagination chain
+type pageState struct {
+	mu           sync.Mutex
+	fingerprints map[uint64]bool
+	items        map[uint64]bool
+}
+
+func newPageState() *pageState {
+	return &pageState{
+		fingerprints: make(map[uint64]bool),
+		items:        make(map[uint64]bool),
+	}
+}
+
+// observe records the snapshot of a page, it returns the reason to stop, or "" to continue
+func (s *pageState) observe(snap *pageSnapshot, stop *PageStop) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stop.RepeatedPage && s.fingerprints[snap.fingerprint] {
+		return "repeated page"
+	}
+
+	first := len(s.fingerprints) == 0
+	s.fingerprints[snap.fingerprint] = true
+
+	unseen := 0
+
+	for _, item := range snap.items {
+		if !s.items[item] {
+			s.items[item] = true
+			unseen++
+		}
+	}
+
+	if stop.NoNewItems && stop.ItemSelector != "" && !first && unseen == 0 {
+		return "no new items"
+	}
+
+	return ""
+}
+
+// paging is the pagination chain and page number of a turned page
+type paging struct {
+	chain uint32
+	page  int
+}
+
+// pagingOf returns the pagination chain and page number of r,
+// a request not turned by pagination is the first page of its own chain.
+func pagingOf(r *Request) (uint32, int, bool) {
+	if r.paging != nil {
+		return r.paging.chain, r.paging.page, true
+	}
+
+	return r.ID, 1, false
+}
+
+// nextPage returns the seed of the next page of r
+func nextPage(r *Request, chain uint32, page int) *Request {
+	return &Request{
+		Depth:  r.Depth,
+		Ctx:    r.Ctx.Child(),
+		paging: &paging{chain: chain, page: page},
+	}
+}
+
+// pagedPaginators returns the paginators of resp with their chain states, a turned page
+// belongs to the paginators which turned it, and a first page to the applied ones.
+func (c *Collector) pagedPaginators(r *Request) ([]*paginatorContainer, uint32, int) {
+	chain, page, turned := pagingOf(r)
+
+	var paginators []*paginatorContainer
+
+	for _, entry := range c.paginators.snapshot() {
+		p := entry.value
+		if entry.detached.Load() {
+			continue
+		}
+
+		if turned {
+			if p.state(chain, false) == nil {
+				continue
+			}
+		} else if !p.Filter.applies(r) || !p.Filter.fire() {
+			continue
+		}
+
+		paginators = append(paginators, p)
+	}
+
+	return paginators, chain, page
+}
+
+// loadPages checks the stop conditions of a loaded page, and loads the appended pages.
+// It returns false if the page is skipped by stop conditions.
+func (c *Collector) loadPages(resp *Response, paginators []*paginatorContainer, chain uint32, page int) (bool, error) {
+	if len(paginators) == 0 {
+		return true, nil
+	}
+
+	c.UpdatePageNum(uint32(page))
+
+	for _, p := range paginators {
+		pg := p.pager(resp, page)
+
+		snap, err := pg.snapshot()
+		if err != nil {
+			return false, err
+		}
+
+		if reason := p.state(chain, true).observe(snap, &p.Stop); reason != "" {
+			p.end(chain, reason)
+			return false, nil
+		}
+
+		if p.Strategy.Appends() {
+			if err := c.appendPages(p, pg, chain); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// appendPages loads the next pages into the page of pg until stop
+func (c *Collector) appendPages(p *paginatorContainer, pg *Pager, chain uint32) error {
+	defer p.end(chain, "appended")
+
+	for {
+		if p.Stop.MaxPages > 0 && pg.Page >= p.Stop.MaxPages {
+			return nil
+		}
+
+		if err := c.handleMaxPageNum(); err != nil {
+			return nil
+		}
+
+		if _, err := p.Strategy.Next(pg); err != nil {
+			if errors.Is(err, ErrNoNextPage) {
+				return nil
+			}
+
+			return err
+		}
+
+		pg.Page++
+		c.UpdatePageNum(uint32(pg.Page))
+
+		snap, err := pg.snapshot()
+		if err != nil {
+			return err
+		}
+
+		if reason := p.state(chain, true).observe(snap, &p.Stop); reason != "" {
+			return nil
+		}
+	}
+}
+
+// turnPages turns resp to the next page with the paginators which don't append
+func (c *Collector) turnPages(resp *Response, paginators []*paginatorContainer, chain uint32, page int) error {
+	for _, p := range paginators {
+		if p.Strategy.Appends() {
+			continue
+		}
+
+		if err := c.turnPage(resp, p, chain, page); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Collector) turnPage(resp *Response, p *paginatorContainer, chain uint32, page int) error {
+	if p.Stop.MaxPages > 0 && page >= p.Stop.MaxPages {
+		p.end(chain, "max pages")
+		return nil
+	}
+
+	next, err := p.Strategy.Next(p.pager(resp, page))
+	if errors.Is(err, ErrNoNextPage) {
+		p.end(chain, "last page")
+		return nil
+	}
+
+	if err != nil {
+		p.end(chain, "error")
+		return err
+	}
+
+	seed := nextPage(resp.Request, chain, page+1)
+
+	if next != "" {
+		err := c.scrapeRequest(next, seed)
+		if IsSkipError(err) {
+			p.end(chain, err.Error())
+			return nil
+		}
+
+		return err
+	}
+
+	return c.handleTurnedPage(resp, seed)
+}
+
+// handleTurnedPage handles the page loaded into the page of resp as a new response
+func (c *Collector) handleTurnedPage(resp *Response, seed *Request) error {
+	info, err := resp.Page.Info()
+	if err != nil {
+		return err
+	}
+
+	URL, err := ParseUrl(info.URL)
+	if err != nil {
+		return err
+	}
+
+	request := &Request{
+		ID:     atomic.AddUint32(&c.requestCount, 1),
+		URL:    URL,
+		Ctx:    seed.Ctx,
+		Depth:  seed.Depth,
+		paging: seed.paging,
+
+		collector: c,
+		bot:       resp.Request.bot,
+		page:      resp.Page,
+	}
+
+	c.emitRequest(EventNavigated, request, nil)
+
+	atomic.AddUint32(&c.responseCount, 1)
+
+	response := &Response{
+		Request: request,
+		Page:    resp.Page,
+		Ctx:     seed.Ctx,
+	}
+
+	c.handleOnResponse(response)
+
+	return c.handlePage(response)
+}
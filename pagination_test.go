@@ -0,0 +1,152 @@
+package roddy
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type PaginationSuite struct {
+	suite.Suite
+	ts *httptest.Server
+}
+
+func TestPagination(t *testing.T) {
+	suite.Run(t, new(PaginationSuite))
+}
+
+func (s *PaginationSuite) SetupSuite() {
+	s.ts = newTestServer()
+}
+
+func (s *PaginationSuite) TearDownSuite() {
+	s.ts.Close()
+}
+
+func (s *PaginationSuite) Test_00_Pagination() {
+	page := func(items ...string) string {
+		var b strings.Builder
+		b.WriteString(`<html><body><div class="ad">` + fmt.Sprint(len(items)) + `</div><ul>`)
+
+		for _, it := range items {
+			b.WriteString(`<li class="item">` + it + `</li>`)
+		}
+
+		b.WriteString(`</ul><a class="next" href="?page=2">Next</a></body></html>`)
+
+		return b.String()
+	}
+
+	snap := func(html, selector string) *pageSnapshot {
+		sn, err := snapshotHTML(html, selector)
+		s.Require().Nil(err)
+
+		return sn
+	}
+
+	s.Len(snap(page("a", "b"), "li.item").items, 2)
+	s.Equal(snap(page("a", "b"), "li.item").fingerprint, snap(page("a", "b"), "li.item").fingerprint)
+	s.NotEqual(snap(page("a"), "").fingerprint, snap(page("b"), "").fingerprint)
+
+	stop := &PageStop{ItemSelector: "li.item", NoNewItems: true, RepeatedPage: true}
+	st := newPageState()
+	s.Equal("", st.observe(snap(page("a", "b"), stop.ItemSelector), stop))
+	s.Equal("", st.observe(snap(page("c", "d"), stop.ItemSelector), stop))
+	s.Equal("repeated page", st.observe(snap(page("a", "b"), stop.ItemSelector), stop))
+	s.Equal("no new items", st.observe(snap(page("b", "c"), stop.ItemSelector), stop))
+	s.Equal("", st.observe(snap(page("a", "b", "c", "d", "e"), stop.ItemSelector), stop), "appended page has new items")
+
+	u, _ := ParseUrl("https://example.com/list?page=1")
+	r := &Request{ID: 7, URL: u, Depth: 1, Ctx: NewContext()}
+
+	next, err := nextLinkOf(page("a"), r, "a.next")
+	s.Nil(err)
+	s.Equal("https://example.com/list?page=2", next)
+
+	_, err = nextLinkOf(page("a"), r, "a.prev")
+	s.ErrorIs(err, ErrNoNextPage)
+
+	next, err = PageURL("https://example.com/list?page=%d").Next(&Pager{Page: 2})
+	s.Nil(err)
+	s.Equal("https://example.com/list?page=3", next)
+
+	c0 := NewCollector()
+	c0.Paginate(PageURL("https://example.com/list?page=%d"), PageStop{ItemSelector: "li.item"})
+	c0.Paginate(PageURL("https://example.com/list?page=%d"), PageStop{})
+
+	stops := c0.paginators.snapshot()
+	s.Equal(PageStop{ItemSelector: "li.item", NoNewItems: true, RepeatedPage: true, WaitChange: _defaultWaitChange}, stops[0].value.Stop)
+	s.Equal(PageStop{RepeatedPage: true, WaitChange: _defaultWaitChange}, stops[1].value.Stop, "PageURL stops on content")
+
+	chain, pg, turned := pagingOf(r)
+	s.Equal([]interface{}{uint32(7), 1, false}, []interface{}{chain, pg, turned}, "first page of its own chain")
+
+	turnedReq := nextPage(r, chain, 2)
+	turnedReq.ID, turnedReq.URL = 8, u
+
+	chain, pg, turned = pagingOf(turnedReq)
+	s.Equal([]interface{}{uint32(7), 2, true}, []interface{}{chain, pg, turned})
+
+	buf, err := turnedReq.Marshal()
+	s.Nil(err)
+	s.NotContains(string(buf), "@page", "paging is not kept in Ctx")
+
+	// mock click children share the depth of the page and inherit its Ctx
+	_, _, turned = pagingOf(&Request{ID: 9, URL: u, Depth: 1, Ctx: turnedReq.Ctx.Child()})
+	s.False(turned, "children of a turned page start their own chain")
+}
+
+func (s *PaginationSuite) Test_01_Paginate() {
+	scraped := func(strategy PaginationStrategy, stop PageStop) []string {
+		c := NewCollector()
+		c.Paginate(strategy, stop)
+
+		var pages []string
+
+		c.OnScraped(func(r *Response) {
+			pages = append(pages, r.Request.URL.Query().Get("page"))
+		})
+
+		s.Nil(c.Visit(s.ts.URL + "/list?page=1"))
+
+		return pages
+	}
+
+	s.Equal([]string{"1", "2", "3"}, scraped(NextLink("a.next"), PageStop{}), "stops without next link")
+	s.Equal([]string{"1", "2"}, scraped(NextLink("a.next"), PageStop{MaxPages: 2}))
+
+	s.Equal([]string{"1", "2", "3"}, scraped(PageURL(s.ts.URL+"/list?page=%d"), PageStop{ItemSelector: "li.item"}),
+		"stops at the page without new items")
+	s.Equal([]string{"1", "2", "3", "4"}, scraped(PageURL(s.ts.URL+"/list?page=%d"), PageStop{}),
+		"stops at the repeated empty page")
+}
+
+func (s *PaginationSuite) Test_02_PaginateMockClick() {
+	c := NewCollector(AllowURLRevisit(true))
+	c.Paginate(NextLink("a.next"), PageStop{ItemSelector: "li.item"}, Once())
+
+	clicked := make(map[string]bool)
+	pages := make(map[string]bool)
+
+	// the details of each list page are opened by mock click
+	c.OnHTML("ul", func(e *SerpElement) error {
+		u := e.Request.URL.String()
+		if clicked[u] {
+			return nil
+		}
+
+		clicked[u] = true
+
+		return e.Request.VisitByMockClick()
+	})
+
+	c.OnScraped(func(r *Response) {
+		pages[r.Request.URL.Query().Get("page")] = true
+	})
+
+	s.Nil(c.Visit(s.ts.URL + "/list?page=1"))
+	s.Equal(map[string]bool{"1": true, "2": true, "3": true}, pages, "mock click children don't end the chain")
+}
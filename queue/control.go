@@ -0,0 +1,78 @@
+package queue
+
+import (
+	"context"
+)
+
+// Pause stops dispatching new requests, in-flight requests keep running.
+// A paused Run does not return even if the queue is empty.
+func (q *Queue) Pause() {
+	q.mut.Lock()
+	q.paused = true
+	q.mut.Unlock()
+
+	q.signal()
+}
+
+// Resume continues dispatching requests after Pause.
+func (q *Queue) Resume() {
+	q.mut.Lock()
+	q.paused = false
+	q.mut.Unlock()
+
+	q.signal()
+}
+
+// IsPaused returns true if the queue is paused
+func (q *Queue) IsPaused() bool {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	return q.paused
+}
+
+// Drain stops taking new requests and waits until in-flight requests are finished
+// and Run returns, requests not taken are kept in storage for next Run.
+// It returns ctx.Err() if ctx is done before that.
+func (q *Queue) Drain(ctx context.Context) error {
+	done := q.startDrain()
+	if done == nil {
+		return nil
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stop drains the running queue without waiting.
+func (q *Queue) Stop() {
+	q.startDrain()
+}
+
+// startDrain returns the done channel of current Run, nil if not running.
+func (q *Queue) startDrain() <-chan struct{} {
+	q.mut.Lock()
+	if !q.running {
+		q.mut.Unlock()
+		return nil
+	}
+
+	q.draining = true
+	done := q.done
+	q.mut.Unlock()
+
+	q.signal()
+
+	return done
+}
+
+func (q *Queue) state() (paused, draining bool) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	return q.paused, q.draining
+}
@@ -0,0 +1,448 @@
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"roddy"
+)
+
+const (
+	_offsetFile = "queue.offset"
+	// "<generation> <offset>\n", fixed width so it can be rewritten in place
+	_offsetFormat = "%020d %020d\n"
+	_offsetSize   = 42
+
+	_defaultCompactThreshold = 4 << 20
+)
+
+// ErrInvalidRecord is returned when a request cannot be stored as a single log line
+var ErrInvalidRecord = errors.New("Queue record contains newline")
+
+// FileStorage is a persistent implementation of the AckStorage interface.
+// FileStorage appends requests to a log file, one request per line, and
+// keeps the offset of acknowledged requests in a separate index file, so a
+// restarted Queue continues where it stopped, and the requests popped but
+// not acknowledged before a crash are consumed again. The offset only moves over
+// acknowledged requests in log order, so the requests acknowledged after a pending
+// one may be consumed twice after a crash, until the log is compacted.
+type FileStorage struct {
+	// Dir is the directory of the log and index files
+	Dir string
+	// MaxSize defines the capacity of the queue.
+	// New requests are discarded if the queue size reaches MaxSize
+	MaxSize int
+	// Sync calls fsync after each write, it survives power loss but is much slower
+	Sync bool
+	// CompactThreshold is the number of acknowledged bytes which triggers a compaction,
+	// the log is compacted only if more than half of it is acknowledged.
+	// Set it to negative to disable automatic compaction.
+	CompactThreshold int64
+
+	lock       *sync.Mutex
+	log        *os.File
+	index      *os.File
+	generation int64
+	// offset is the end of the acknowledged prefix, it's persisted in the index
+	offset int64
+	// read is the end of the popped requests
+	read int64
+	end  int64
+	// size is the number of requests not popped yet
+	size int
+	// inflight are the popped requests after offset, in log order
+	inflight  []*inflightRecord
+	lastToken uint64
+	// garbage is the number of acknowledged bytes in the log
+	garbage int64
+}
+
+// inflightRecord is a popped request waiting for its Ack
+type inflightRecord struct {
+	token      uint64
+	start, end int64
+	acked      bool
+}
+
+// NewFileStorage creates a FileStorage in dir.
+func NewFileStorage(dir string) *FileStorage {
+	return &FileStorage{
+		Dir:              dir,
+		CompactThreshold: _defaultCompactThreshold,
+	}
+}
+
+// Init opens (or creates) the log and index files, and restores the queue state.
+func (q *FileStorage) Init() error {
+	q.lock = &sync.Mutex{}
+
+	if err := os.MkdirAll(q.Dir, 0o755); err != nil {
+		return err
+	}
+
+	index, err := os.OpenFile(filepath.Join(q.Dir, _offsetFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+
+	q.index = index
+
+	if err := q.readOffset(); err != nil {
+		return err
+	}
+
+	if err := q.openLog(); err != nil {
+		return err
+	}
+
+	q.removeStaleLogs()
+
+	return q.recover()
+}
+
+// Close closes the underlying files.
+func (q *FileStorage) Close() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return errors.Join(q.log.Close(), q.index.Close())
+}
+
+// AddRequest implements Storage.AddRequest()
+func (q *FileStorage) AddRequest(r []byte) error {
+	if bytes.IndexByte(r, '\n') != -1 {
+		return ErrInvalidRecord
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.MaxSize > 0 && q.size >= q.MaxSize {
+		return roddy.ErrQueueFull
+	}
+
+	line := make([]byte, 0, len(r)+1)
+	line = append(line, r...)
+	line = append(line, '\n')
+
+	n, err := q.log.WriteAt(line, q.end)
+	if err != nil {
+		return err
+	}
+
+	if q.Sync {
+		if err := q.log.Sync(); err != nil {
+			return err
+		}
+	}
+
+	q.end += int64(n)
+	q.size++
+
+	return nil
+}
+
+// GetRequest implements Storage.GetRequest(), the request is acknowledged at once
+func (q *FileStorage) GetRequest() ([]byte, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	r, token, err := q.pop()
+	if r == nil || err != nil {
+		return r, err
+	}
+
+	return r, q.ack(token)
+}
+
+// GetRequestAck implements AckStorage.GetRequestAck()
+func (q *FileStorage) GetRequestAck() ([]byte, uint64, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.pop()
+}
+
+// Ack implements AckStorage.Ack()
+func (q *FileStorage) Ack(token uint64) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.ack(token)
+}
+
+func (q *FileStorage) pop() ([]byte, uint64, error) {
+	if q.size == 0 {
+		return nil, 0, nil
+	}
+
+	line, err := q.readLine()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	q.lastToken++
+	q.inflight = append(q.inflight, &inflightRecord{
+		token: q.lastToken,
+		start: q.read,
+		end:   q.read + int64(len(line)),
+	})
+
+	q.read += int64(len(line))
+	q.size--
+
+	return line[:len(line)-1], q.lastToken, nil
+}
+
+// ack marks the record of token acknowledged, and moves the offset over the acknowledged prefix.
+func (q *FileStorage) ack(token uint64) error {
+	found := false
+
+	for _, rec := range q.inflight {
+		if rec.token == token && !rec.acked {
+			rec.acked = true
+			q.garbage += rec.end - rec.start
+			found = true
+
+			break
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("unknown queue token %d", token)
+	}
+
+	n := 0
+	for n < len(q.inflight) && q.inflight[n].acked {
+		q.offset = q.inflight[n].end
+		n++
+	}
+
+	if n > 0 {
+		q.inflight = q.inflight[n:]
+
+		if err := q.writeOffset(); err != nil {
+			return err
+		}
+	}
+
+	if q.shouldCompact() {
+		return q.compact()
+	}
+
+	return nil
+}
+
+// QueueSize implements Storage.QueueSize()
+func (q *FileStorage) QueueSize() (int, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.size, nil
+}
+
+// Compact drops acknowledged requests from the log.
+func (q *FileStorage) Compact() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.compact()
+}
+
+func (q *FileStorage) shouldCompact() bool {
+	if q.CompactThreshold < 0 {
+		return false
+	}
+
+	return q.garbage >= q.CompactThreshold && q.garbage*2 >= q.end
+}
+
+// compact copies the unacknowledged records and the unread part into the log of next
+// generation, the index is switched only after the new log is completely written.
+func (q *FileStorage) compact() error {
+	if q.garbage == 0 {
+		return nil
+	}
+
+	next, err := os.OpenFile(q.logPath(q.generation+1), os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+
+	var (
+		pos      int64
+		inflight []*inflightRecord
+	)
+
+	for _, rec := range q.inflight {
+		if rec.acked {
+			continue
+		}
+
+		n, err := io.Copy(next, io.NewSectionReader(q.log, rec.start, rec.end-rec.start))
+		if err != nil {
+			next.Close()
+			return err
+		}
+
+		inflight = append(inflight, &inflightRecord{token: rec.token, start: pos, end: pos + n})
+		pos += n
+	}
+
+	if _, err := io.Copy(next, io.NewSectionReader(q.log, q.read, q.end-q.read)); err != nil {
+		next.Close()
+		return err
+	}
+
+	if err := next.Sync(); err != nil {
+		next.Close()
+		return err
+	}
+
+	prev := q.log
+	prevPath := q.logPath(q.generation)
+
+	q.log = next
+	q.generation++
+	q.end = pos + q.end - q.read
+	q.read = pos
+	q.offset = 0
+	q.inflight = inflight
+	q.garbage = 0
+
+	if err := q.writeOffset(); err != nil {
+		return err
+	}
+
+	prev.Close()
+
+	return os.Remove(prevPath)
+}
+
+func (q *FileStorage) logPath(generation int64) string {
+	return filepath.Join(q.Dir, fmt.Sprintf("queue.%d.log", generation))
+}
+
+func (q *FileStorage) openLog() error {
+	f, err := os.OpenFile(q.logPath(q.generation), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+
+	q.log = f
+
+	return nil
+}
+
+func (q *FileStorage) removeStaleLogs() {
+	matches, _ := filepath.Glob(filepath.Join(q.Dir, "queue.*.log"))
+	for _, m := range matches {
+		if m != q.logPath(q.generation) {
+			os.Remove(m)
+		}
+	}
+}
+
+// recover counts the pending requests, and drops the last line if it was partially written.
+func (q *FileStorage) recover() error {
+	info, err := q.log.Stat()
+	if err != nil {
+		return err
+	}
+
+	q.end = info.Size()
+	if q.offset > q.end {
+		return fmt.Errorf("queue offset %d exceeds log size %d", q.offset, q.end)
+	}
+
+	// requests popped but not acknowledged before are read again
+	q.read = q.offset
+	q.garbage = q.offset
+
+	r := bufio.NewReader(io.NewSectionReader(q.log, q.offset, q.end-q.offset))
+	complete := q.offset
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		complete += int64(len(line))
+		q.size++
+	}
+
+	if complete != q.end {
+		if err := q.log.Truncate(complete); err != nil {
+			return err
+		}
+
+		q.end = complete
+	}
+
+	return nil
+}
+
+// readLine reads the line at read, including the trailing newline.
+func (q *FileStorage) readLine() ([]byte, error) {
+	var line []byte
+
+	chunk := make([]byte, 4096)
+
+	for pos := q.read; pos < q.end; {
+		n, err := q.log.ReadAt(chunk[:min(int64(len(chunk)), q.end-pos)], pos)
+		if i := bytes.IndexByte(chunk[:n], '\n'); i != -1 {
+			return append(line, chunk[:i+1]...), nil
+		}
+
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+
+		line = append(line, chunk[:n]...)
+		pos += int64(n)
+	}
+
+	return nil, io.ErrUnexpectedEOF
+}
+
+func (q *FileStorage) readOffset() error {
+	buf := make([]byte, _offsetSize)
+
+	n, err := q.index.ReadAt(buf, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	if n == 0 {
+		return q.writeOffset()
+	}
+
+	if _, err := fmt.Sscanf(string(buf[:n]), "%d %d", &q.generation, &q.offset); err != nil {
+		return fmt.Errorf("invalid queue offset file: %w", err)
+	}
+
+	return nil
+}
+
+func (q *FileStorage) writeOffset() error {
+	if _, err := q.index.WriteAt([]byte(fmt.Sprintf(_offsetFormat, q.generation, q.offset)), 0); err != nil {
+		return err
+	}
+
+	if q.Sync {
+		return q.index.Sync()
+	}
+
+	return nil
+}
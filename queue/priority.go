@@ -0,0 +1,115 @@
+package queue
+
+import (
+	"container/heap"
+	"encoding/json"
+	"sync"
+
+	"roddy"
+)
+
+// PriorityInMemoryQueueStorage is an in-memory implementation of the Storage interface,
+// requests with higher Request.Priority are consumed first,
+// and requests with equal priority keep FIFO order.
+type PriorityInMemoryQueueStorage struct {
+	// MaxSize defines the capacity of the queue.
+	// New requests are discarded if the queue size reaches MaxSize
+	MaxSize int
+	lock    *sync.Mutex
+	items   priorityItems
+	seq     uint64
+}
+
+type priorityItem struct {
+	Request  []byte
+	Priority int
+	seq      uint64
+}
+
+func NewPriorityInMemory(size int) *PriorityInMemoryQueueStorage {
+	return &PriorityInMemoryQueueStorage{MaxSize: size}
+}
+
+func (q *PriorityInMemoryQueueStorage) Init() error {
+	q.lock = &sync.Mutex{}
+
+	return nil
+}
+
+func (q *PriorityInMemoryQueueStorage) AddRequest(r []byte) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.MaxSize > 0 && len(q.items) >= q.MaxSize {
+		return roddy.ErrQueueFull
+	}
+
+	q.seq++
+	heap.Push(&q.items, &priorityItem{
+		Request:  r,
+		Priority: requestPriority(r),
+		seq:      q.seq,
+	})
+
+	return nil
+}
+
+func (q *PriorityInMemoryQueueStorage) GetRequest() ([]byte, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(q.items) == 0 {
+		return nil, nil
+	}
+
+	item := heap.Pop(&q.items).(*priorityItem)
+
+	return item.Request, nil
+}
+
+func (q *PriorityInMemoryQueueStorage) QueueSize() (int, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return len(q.items), nil
+}
+
+// requestPriority reads Priority from a serialized request,
+// malformed requests get the default priority 0.
+func requestPriority(r []byte) int {
+	req := struct{ Priority int }{}
+	if err := json.Unmarshal(r, &req); err != nil {
+		return 0
+	}
+
+	return req.Priority
+}
+
+// priorityItems implements heap.Interface
+type priorityItems []*priorityItem
+
+func (p priorityItems) Len() int { return len(p) }
+
+func (p priorityItems) Less(i, j int) bool {
+	if p[i].Priority != p[j].Priority {
+		return p[i].Priority > p[j].Priority
+	}
+
+	return p[i].seq < p[j].seq
+}
+
+func (p priorityItems) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+func (p *priorityItems) Push(x any) {
+	*p = append(*p, x.(*priorityItem))
+}
+
+func (p *priorityItems) Pop() any {
+	old := *p
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*p = old[:n-1]
+
+	return item
+}
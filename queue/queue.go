@@ -1,15 +1,21 @@
 package queue
 
 import (
+	"errors"
 	"sync"
+	"time"
 
 	"roddy"
 
 	whatwgUrl "github.com/nlnwa/whatwg-url/url"
+	"github.com/rs/zerolog/log"
 )
 
 const _stop = true
 
+// errNoRequest is returned when the storage is drained by others between QueueSize and GetRequest
+var errNoRequest = errors.New("no request in queue")
+
 var urlParser = whatwgUrl.NewParser(whatwgUrl.WithPercentEncodeSinglePercentSign())
 
 // Storage is the interface of the queue's storage backend
@@ -26,18 +32,61 @@ type Storage interface {
 	QueueSize() (int, error)
 }
 
+// AckStorage is a Storage which keeps popped requests until they're acknowledged,
+// so the requests in flight are not lost on a crash. Queue acknowledges a request
+// when it's done, or after it's put back to the storage for a retry or a schedule.
+type AckStorage interface {
+	Storage
+	// GetRequestAck pops the next request as GetRequest does, and returns
+	// the token to acknowledge it, the request is consumed again after a restart until then.
+	GetRequestAck() ([]byte, uint64, error)
+	// Ack acknowledges the request of token
+	Ack(token uint64) error
+}
+
 // Queue is a request queue which uses a Collector to consume
 // requests in multiple threads
 type Queue struct {
 	// Threads defines the number of consumer threads
 	Threads int
 	storage Storage
-	wake    chan struct{}
-	mut     sync.Mutex // guards wake and running
-	running bool
+	// wake notifies the loop about new requests and state changes
+	wake chan struct{}
+	mut  sync.Mutex // guards running, paused, draining, done and doneCallbacks
+	// running is true while Run is in progress
+	running  bool
+	paused   bool
+	draining bool
+	// done is closed when the current Run returns
+	done chan struct{}
+
+	// hostFair enables the host-aware scheduler
+	hostFair    bool
+	hostLimit   int
+	hostWeights map[string]int
+	hostBuffer  int
+
+	maxRetries  int
+	backoff     BackoffFunc
+	deadLetters Storage
+	quarantine  Storage
+
+	doneCallbacks []RequestDoneCallback
+
+	// tokens are the AckStorage tokens of loaded requests, it's only used by the loop
+	tokens map[*roddy.Request]uint64
+}
+
+// result is the outcome of a request done by runner
+type result struct {
+	req *roddy.Request
+	err error
 }
 
-func New(threads int, s Storage) (*Queue, error) {
+// Option is the option of Queue
+type Option func(*Queue)
+
+func New(threads int, s Storage, opts ...Option) (*Queue, error) {
 	if s == nil {
 		s = NewInMemory(100000)
 	}
@@ -46,11 +95,61 @@ func New(threads int, s Storage) (*Queue, error) {
 		return nil, err
 	}
 
-	return &Queue{
-		Threads: threads,
-		storage: s,
-		running: true,
-	}, nil
+	q := &Queue{
+		Threads:    threads,
+		storage:    s,
+		wake:       make(chan struct{}, 1),
+		hostBuffer: _defaultHostBuffer,
+		maxRetries: _defaultMaxRetries,
+		backoff:    ExponentialBackoff,
+		tokens:     make(map[*roddy.Request]uint64),
+	}
+
+	for _, f := range opts {
+		f(q)
+	}
+
+	if q.deadLetters == nil {
+		q.deadLetters = NewInMemory(0)
+	}
+
+	if q.quarantine == nil {
+		q.quarantine = NewInMemory(0)
+	}
+
+	for _, st := range []Storage{q.deadLetters, q.quarantine} {
+		if err := st.Init(); err != nil {
+			return nil, err
+		}
+	}
+
+	return q, nil
+}
+
+// HostFair enables host-aware scheduling: loaded requests are kept in per-host
+// sub-queues and served round-robin, so one big host cannot occupy all threads.
+//   - limit: max concurrent requests of each host, 0 means no limit.
+func HostFair(limit int) Option {
+	return func(q *Queue) {
+		q.hostFair = true
+		q.hostLimit = limit
+	}
+}
+
+// HostWeights sets how many requests a host can take in each round-robin round,
+// hosts not in weights have weight 1. It only works with HostFair.
+func HostWeights(weights map[string]int) Option {
+	return func(q *Queue) {
+		q.hostWeights = weights
+	}
+}
+
+// HostBuffer sets the max number of requests loaded into the per-host sub-queues.
+// Larger buffer finds more hosts from the storage, but uses more memory.
+func HostBuffer(n int) Option {
+	return func(q *Queue) {
+		q.hostBuffer = n
+	}
 }
 
 // IsEmpty returns true if the queue is empty
@@ -64,36 +163,93 @@ func (q *Queue) Size() (int, error) {
 	return q.storage.QueueSize()
 }
 
+// AddURL adds a URL of depth 1, the same as Collector.Visit
 func (q *Queue) AddURL(URL string) error {
-	u2, err := roddy.ParseUrl(URL)
+	return q.AddURLWithPriority(URL, 0)
+}
+
+// AddURLWithPriority adds a URL with priority, the priority only takes effect
+// with a priority-aware storage like PriorityInMemoryQueueStorage.
+func (q *Queue) AddURLWithPriority(URL string, priority int) error {
+	r, err := newRequest(URL, 1, nil)
 	if err != nil {
 		return err
 	}
 
-	r := &roddy.Request{
-		URL: u2,
+	r.Priority = priority
+
+	return q.AddRequest(r)
+}
+
+// AddURLWithContext adds a URL with depth and ctx, ctx is passed to the callbacks
+// as Request.Ctx, so it must be serializable by the storage.
+func (q *Queue) AddURLWithContext(URL string, depth int, ctx *roddy.Context) error {
+	r, err := newRequest(URL, depth, ctx)
+	if err != nil {
+		return err
 	}
 
-	return q.storeRequest(r)
+	return q.AddRequest(r)
 }
 
 func (q *Queue) AddRequest(r *roddy.Request) error {
-	q.mut.Lock()
-	waken := q.wake != nil
-	q.mut.Unlock()
+	if err := q.storeRequest(r); err != nil {
+		return err
+	}
+
+	q.signal()
+
+	return nil
+}
+
+// AddRequests adds requests in batch, it stops at the first error,
+// and returns the number of added requests.
+func (q *Queue) AddRequests(reqs []*roddy.Request) (int, error) {
+	n := 0
+
+	defer func() {
+		if n > 0 {
+			q.signal()
+		}
+	}()
+
+	for _, r := range reqs {
+		if err := q.storeRequest(r); err != nil {
+			return n, err
+		}
 
-	if !waken {
-		return q.storeRequest(r)
+		n++
 	}
 
-	err := q.storeRequest(r)
+	return n, nil
+}
+
+// AddRequestAt adds a request which is consumed not before t.
+func (q *Queue) AddRequestAt(r *roddy.Request, t time.Time) error {
+	r.NotBefore = t
+	return q.AddRequest(r)
+}
+
+// AddRequestAfter adds a request which is consumed after d.
+func (q *Queue) AddRequestAfter(r *roddy.Request, d time.Duration) error {
+	return q.AddRequestAt(r, time.Now().Add(d))
+}
+
+func newRequest(URL string, depth int, ctx *roddy.Context) (*roddy.Request, error) {
+	u2, err := roddy.ParseUrl(URL)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	q.wake <- struct{}{}
+	if ctx == nil {
+		ctx = roddy.NewContext()
+	}
 
-	return nil
+	return &roddy.Request{
+		URL:   u2,
+		Depth: depth,
+		Ctx:   ctx,
+	}, nil
 }
 
 func (q *Queue) storeRequest(r *roddy.Request) error {
@@ -105,52 +261,104 @@ func (q *Queue) storeRequest(r *roddy.Request) error {
 	return q.storage.AddRequest(buf)
 }
 
-func (q *Queue) Run(c *roddy.Collector) error {
+// signal wakes up the loop without blocking, a pending signal is enough.
+func (q *Queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run consumes the queue until it's empty or drained,
+// the returned error is only about the storage, check RunReport for the requests.
+// Run can be called again after it returns.
+func (q *Queue) Run(c *roddy.Collector) (*RunReport, error) {
 	q.mut.Lock()
-	if q.wake != nil && q.running == true {
+	if q.running {
 		q.mut.Unlock()
 		panic("cannot call duplicate Queue.Run")
 	}
 
-	q.wake = make(chan struct{})
 	q.running = true
+	q.draining = false
+	q.done = make(chan struct{})
 	q.mut.Unlock()
 
+	defer func() {
+		q.mut.Lock()
+		q.running = false
+		close(q.done)
+		q.mut.Unlock()
+	}()
+
 	reqChan := make(chan *roddy.Request)
-	complete, errChan := make(chan struct{}), make(chan error, 1)
+	complete, errChan := make(chan *result), make(chan error, 1)
 
 	for i := 0; i < q.Threads; i++ {
 		go independentRunner(reqChan, complete)
 	}
 
-	go q.loop(c, reqChan, complete, errChan)
+	start := time.Now()
+	report := newRunReport()
+
+	go q.loop(c, reqChan, complete, errChan, report)
 
 	defer close(reqChan)
 
-	return <-errChan
-}
+	err := <-errChan
+	report.Duration = time.Since(start)
 
-// Stop will stop the running queue
-func (q *Queue) Stop() {
-	q.mut.Lock()
-	q.running = false
-	q.mut.Unlock()
+	return report, err
 }
 
-func (q *Queue) loop(c *roddy.Collector, reqChan chan<- *roddy.Request, complete <-chan struct{}, errc chan<- error) {
-	var active int
+func (q *Queue) loop(c *roddy.Collector, reqChan chan<- *roddy.Request, complete <-chan *result, errc chan<- error, report *RunReport) {
+	var (
+		active  int
+		sched   *hostScheduler
+		delayed delayedRequests
+		// held is a loaded request which is not sent before pause or drain
+		held *roddy.Request
+	)
+
+	if q.hostFair {
+		sched = newHostScheduler(q.hostLimit, q.hostWeights)
+	}
 
 	for {
 		size, err := q.storage.QueueSize()
 		if err != nil {
+			// runners block on complete, so wait for the active requests before stopping
+			for ; active > 0; active-- {
+				res := <-complete
+				q.handleResult(c, res, &delayed, report)
+
+				if sched != nil {
+					sched.done(res.req)
+				}
+			}
+
+			q.restore(sched, &delayed, held)
 			errc <- err
 			break
 		}
 
-		if size == 0 && active == 0 || !q.running {
-			// Terminate when
+		paused, draining := q.state()
+
+		pending := delayed.Len()
+		if sched != nil {
+			pending += sched.len()
+		}
+
+		if held != nil {
+			pending++
+		}
+
+		if draining && active == 0 || !paused && size == 0 && active == 0 && pending == 0 {
+			// Terminate when drained, or
 			//   1. No active requests
 			//   2. Empty queue
+			//   3. No request is waiting for retry or its scheduled time
+			q.restore(sched, &delayed, held)
 			errc <- nil
 			break
 		}
@@ -158,16 +366,44 @@ func (q *Queue) loop(c *roddy.Collector, reqChan chan<- *roddy.Request, complete
 		req := &roddy.Request{}
 		sent := reqChan
 
-		if size > 0 {
-			req, err = q.loadRequest(c)
+		switch {
+		case paused || draining:
+			sent = nil
+		case held != nil:
+			req, held = held, nil
+		case sched != nil:
+			// fill the sub-queues first, then pick by host
+			if size > 0 && sched.len() < q.hostBuffer {
+				if req, err = q.loadRequest(c, report); err == nil && !delayed.hold(req) {
+					sched.push(req)
+				}
+
+				continue
+			}
+
+			if req = sched.pop(); req == nil {
+				sent = nil
+			}
+		case size > 0:
+			req, err = q.loadRequest(c, report)
 			if err != nil {
-				// ignore error returned by GetRequest() or UnmarshalRequest()
+				// corrupt entries are quarantined by loadRequest
 				continue
 			}
-		} else {
+
+			if delayed.hold(req) {
+				continue
+			}
+		default:
 			sent = nil
 		}
 
+		// wait for the earliest scheduled request instead of polling
+		var timer *time.Timer
+		if due, ok := delayed.next(); ok {
+			timer = time.NewTimer(time.Until(due))
+		}
+
 	SENT:
 		for {
 			select {
@@ -178,31 +414,171 @@ func (q *Queue) loop(c *roddy.Collector, reqChan chan<- *roddy.Request, complete
 				if sent == nil {
 					break SENT
 				}
-			case <-complete:
+
+				// keep the loaded request until resumed
+				if paused, draining := q.state(); paused || draining {
+					held = req
+					break SENT
+				}
+			case <-timerC(timer):
+				timer = nil
+
+				for _, r := range delayed.popDue(time.Now()) {
+					if err := q.requeue(r); err != nil {
+						log.Error().Err(err).Str("url", r.URL.String()).Msg("cannot re-enqueue request")
+					}
+				}
+
+				if sent == nil {
+					break SENT
+				}
+			case res := <-complete:
 				active--
-				if sent == nil && active == 0 {
+
+				q.handleResult(c, res, &delayed, report)
+
+				if sched != nil {
+					sched.done(res.req)
+
+					// a host slot is released, re-pick
+					if sent == nil {
+						break SENT
+					}
+				}
+
+				if sent == nil && (active == 0 || delayed.Len() > 0) {
 					break SENT
 				}
 			}
 		}
+
+		if timer != nil {
+			timer.Stop()
+		}
 	}
 }
 
-func (q *Queue) loadRequest(c *roddy.Collector) (*roddy.Request, error) {
-	buf, err := q.storage.GetRequest()
+// restore puts requests left in the sub-queues and retry list back to storage.
+func (q *Queue) restore(sched *hostScheduler, delayed *delayedRequests, held *roddy.Request) {
+	reqs := delayed.popAll()
+	if sched != nil {
+		reqs = append(reqs, sched.drain()...)
+	}
+
+	if held != nil {
+		reqs = append(reqs, held)
+	}
+
+	for _, r := range reqs {
+		if err := q.requeue(r); err != nil {
+			log.Error().Err(err).Str("url", r.URL.String()).Msg("cannot restore request")
+		}
+	}
+}
+
+// requeue puts the loaded request back to storage, then acknowledges its previous entry,
+// so it's never lost, but may be consumed twice if it crashes in between.
+func (q *Queue) requeue(r *roddy.Request) error {
+	if err := q.storeRequest(r); err != nil {
+		return err
+	}
+
+	q.ack(r)
+
+	return nil
+}
+
+// ack acknowledges the storage entry of a loaded request, if the storage is an AckStorage.
+func (q *Queue) ack(r *roddy.Request) {
+	token, ok := q.tokens[r]
+	if !ok {
+		return
+	}
+
+	delete(q.tokens, r)
+
+	if err := q.storage.(AckStorage).Ack(token); err != nil {
+		log.Error().Err(err).Str("url", r.URL.String()).Msg("cannot ack request")
+	}
+}
+
+func (q *Queue) handleResult(c *roddy.Collector, res *result, delayed *delayedRequests, report *RunReport) {
+	switch {
+	case res.err == nil:
+		report.addSucceeded()
+	case roddy.IsSkipError(res.err):
+		report.addSkipped()
+	case q.handleFailure(c, res.req, res.err, delayed):
+		// the entry is acknowledged when the retry is stored
+		report.addRetried()
+		return
+	default:
+		report.addFailed(res.err)
+	}
+
+	q.ack(res.req)
+	q.handleOnRequestDone(res.req, res.err)
+}
+
+func (q *Queue) loadRequest(c *roddy.Collector, report *RunReport) (*roddy.Request, error) {
+	var (
+		buf   []byte
+		token uint64
+		err   error
+	)
+
+	as, acked := q.storage.(AckStorage)
+	if acked {
+		buf, token, err = as.GetRequestAck()
+	} else {
+		buf, err = q.storage.GetRequest()
+	}
+
 	if err != nil {
+		log.Error().Err(err).Msg("cannot get request")
 		return nil, err
 	}
 
+	if buf == nil {
+		return nil, errNoRequest
+	}
+
 	copied := make([]byte, len(buf))
 	copy(copied, buf)
 
-	return c.UnmarshalRequest(copied)
+	req, err := c.UnmarshalRequest(copied)
+	if err != nil {
+		report.addQuarantined()
+		q.quarantineEntry(copied, err)
+
+		if acked {
+			if err := as.Ack(token); err != nil {
+				log.Error().Err(err).Msg("cannot ack quarantined entry")
+			}
+		}
+
+		return nil, err
+	}
+
+	if acked {
+		q.tokens[req] = token
+	}
+
+	return req, nil
+}
+
+// timerC returns the channel of timer, nil channel blocks forever if timer is nil.
+func timerC(t *time.Timer) <-chan time.Time {
+	if t == nil {
+		return nil
+	}
+
+	return t.C
 }
 
-func independentRunner(reqChan <-chan *roddy.Request, complete chan<- struct{}) {
+func independentRunner(reqChan <-chan *roddy.Request, complete chan<- *result) {
 	for req := range reqChan {
-		req.Do()
-		complete <- struct{}{}
+		err := req.Do()
+		complete <- &result{req: req, err: err}
 	}
 }
@@ -1,14 +1,24 @@
 package queue
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"roddy"
+	"roddy/storage"
+	"roddy/storage/boltstorage"
 
 	"github.com/coghost/xlog"
 	"github.com/stretchr/testify/suite"
@@ -91,15 +101,775 @@ func (s *QueueSuite) Test_Queue() {
 		atomic.AddUint32(&failure, 1)
 	})
 
-	err = q.Run(c)
+	report, err := q.Run(c)
 
 	s.Nil(err, "Queue.Run() returns no error")
+	s.Equal(int(requests), report.Succeeded, "all requests succeeded")
+	s.Equal(30, report.Quarantined, "error requests are quarantined")
 
 	s.Equal(items, requests, "items equal with requests")
 	s.Equal(success+failure, requests, "success+failure equal with requests")
 	s.LessOrEqual(failure, uint32(0), "has failures")
 }
 
+func (s *QueueSuite) Test_FileStorage() {
+	dir := s.T().TempDir()
+
+	fs := NewFileStorage(dir)
+	fs.CompactThreshold = 1
+	s.Nil(fs.Init())
+
+	for _, r := range []string{"r1", "r2", "r3"} {
+		s.Nil(fs.AddRequest([]byte(r)))
+	}
+
+	s.Equal(ErrInvalidRecord, fs.AddRequest([]byte("a\nb")))
+
+	r, err := fs.GetRequest()
+	s.Nil(err)
+	s.Equal("r1", string(r))
+	s.Nil(fs.Close())
+
+	// restart continues where it stopped
+	fs = NewFileStorage(dir)
+	s.Nil(fs.Init())
+
+	size, _ := fs.QueueSize()
+	s.Equal(2, size)
+
+	for _, want := range []string{"r2", "r3"} {
+		r, err := fs.GetRequest()
+		s.Nil(err)
+		s.Equal(want, string(r))
+	}
+
+	s.Nil(fs.AddRequest([]byte("r4")))
+	s.Nil(fs.Close())
+
+	fs = NewFileStorage(dir)
+	s.Nil(fs.Init())
+
+	r, err = fs.GetRequest()
+	s.Nil(err)
+	s.Equal("r4", string(r))
+
+	r, err = fs.GetRequest()
+	s.Nil(err)
+	s.Nil(r)
+	s.Nil(fs.Close())
+}
+
+func (s *QueueSuite) Test_FileStorageAck() {
+	dir := s.T().TempDir()
+
+	fs := NewFileStorage(dir)
+	fs.CompactThreshold = 1
+	s.Nil(fs.Init())
+
+	for _, r := range []string{"r1", "r2", "r3", "r4"} {
+		s.Nil(fs.AddRequest([]byte(r)))
+	}
+
+	tokens := make(map[string]uint64)
+
+	for _, want := range []string{"r1", "r2", "r3"} {
+		r, token, err := fs.GetRequestAck()
+		s.Nil(err)
+		s.Equal(want, string(r))
+
+		tokens[want] = token
+	}
+
+	size, _ := fs.QueueSize()
+	s.Equal(1, size, "popped requests are not counted")
+
+	// r2 is acked out of order, r1 is still in flight
+	s.Nil(fs.Ack(tokens["r2"]))
+	s.Nil(fs.Ack(tokens["r3"]))
+	s.Error(fs.Ack(tokens["r3"]), "acked twice")
+
+	// the acked records are compacted away, r1 stays
+	s.Nil(fs.Compact())
+	s.Nil(fs.Close())
+
+	fs = NewFileStorage(dir)
+	s.Nil(fs.Init())
+
+	size, _ = fs.QueueSize()
+	s.Equal(2, size, "unacked request survives restart")
+
+	for _, want := range []string{"r1", "r4"} {
+		r, err := fs.GetRequest()
+		s.Nil(err)
+		s.Equal(want, string(r))
+	}
+
+	s.Nil(fs.Close())
+
+	fs = NewFileStorage(dir)
+	s.Nil(fs.Init())
+
+	r, token, err := fs.GetRequestAck()
+	s.Nil(err)
+	s.Nil(r)
+	s.Zero(token)
+	s.Nil(fs.Close())
+}
+
+func (s *QueueSuite) Test_FileStorageCrash() {
+	dir := s.T().TempDir()
+
+	fs := NewFileStorage(dir)
+	q, err := New(1, fs, RetryBackoff(func(int) time.Duration { return time.Hour }))
+	s.Nil(err)
+
+	later, _ := roddy.ParseUrl("http://example.com/later")
+	s.Nil(q.AddRequestAfter(&roddy.Request{URL: later}, time.Hour))
+
+	for _, path := range []string{"/done", "/fail", "/block"} {
+		s.Nil(q.AddURL("http://example.com" + path))
+	}
+
+	var calls atomic.Int32
+
+	blocked, release := make(chan struct{}), make(chan struct{})
+
+	// /done is rejected, the first storage check (/fail) fails, the second one (/block) hangs
+	c := roddy.NewCollector(roddy.URLFilters(regexp.MustCompile(`/fail|/block`)))
+	s.Nil(c.SetStorage(newHookStorage(func() (bool, error) {
+		if calls.Add(1) == 1 {
+			return false, errors.New("boom")
+		}
+
+		close(blocked)
+		<-release
+
+		return true, nil
+	})))
+
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+		q.Run(c)
+	}()
+
+	<-blocked
+
+	// crash: the files are copied while Run holds a delayed, a retried and an active request
+	crashed := s.T().TempDir()
+	for _, name := range []string{_offsetFile, fmt.Sprintf("queue.%d.log", fs.generation)} {
+		buf, err := os.ReadFile(filepath.Join(dir, name))
+		s.Require().Nil(err)
+		s.Require().Nil(os.WriteFile(filepath.Join(crashed, name), buf, 0o644))
+	}
+
+	close(release)
+	q.Stop()
+	<-finished
+	s.Nil(fs.Close())
+
+	// restart on the crashed files, every request in flight is consumed again,
+	// and /done too, as it's acknowledged after the pending /later
+	q, err = New(2, NewFileStorage(crashed))
+	s.Nil(err)
+	s.Equal(4, mustSize(q))
+
+	done := make(chan string, 3)
+
+	q.OnRequestDone(func(r *roddy.Request, err error) {
+		done <- r.URL.Path
+	})
+
+	finished = make(chan struct{})
+
+	go func() {
+		defer close(finished)
+		q.Run(newRejectingCollector())
+	}()
+
+	s.ElementsMatch([]string{"/done", "/fail", "/block"}, []string{<-done, <-done, <-done})
+
+	// /later is still waiting for its time
+	s.Nil(q.Drain(context.Background()))
+	<-finished
+	s.Equal(1, mustSize(q))
+
+	r, err := q.loadRequest(newRejectingCollector(), newRunReport())
+	s.Nil(err)
+	s.Equal("/later", r.URL.Path)
+}
+
+func (s *QueueSuite) Test_PriorityStorage() {
+	ps := NewPriorityInMemory(0)
+	s.Nil(ps.Init())
+
+	for _, r := range []string{
+		`{"URL":"low-1","Priority":-1}`,
+		`{"URL":"normal-1"}`,
+		`{"URL":"high-1","Priority":10}`,
+		`{"URL":"normal-2","Priority":0}`,
+		`{"URL":"high-2","Priority":10}`,
+	} {
+		s.Nil(ps.AddRequest([]byte(r)))
+	}
+
+	var got []string
+
+	for {
+		r, err := ps.GetRequest()
+		s.Nil(err)
+
+		if r == nil {
+			break
+		}
+
+		req := struct{ URL string }{}
+		s.Nil(json.Unmarshal(r, &req))
+		got = append(got, req.URL)
+	}
+
+	s.Equal([]string{"high-1", "high-2", "normal-1", "normal-2", "low-1"}, got)
+}
+
+func (s *QueueSuite) Test_HostScheduler() {
+	sched := newHostScheduler(1, map[string]int{"b.com": 2})
+
+	newReq := func(u string) *roddy.Request {
+		pu, err := roddy.ParseUrl(u)
+		s.Nil(err)
+
+		return &roddy.Request{URL: pu}
+	}
+
+	for _, u := range []string{
+		"http://a.com/1", "http://a.com/2", "http://a.com/3",
+		"http://b.com/1",
+		"http://c.com/1",
+	} {
+		sched.push(newReq(u))
+	}
+
+	s.Equal(5, sched.len())
+
+	r1 := sched.pop()
+	s.Equal("http://a.com/1", r1.URL.String())
+	s.Equal("http://b.com/1", sched.pop().URL.String())
+	s.Equal("http://c.com/1", sched.pop().URL.String())
+	// a.com reaches its limit
+	s.Nil(sched.pop())
+
+	sched.done(r1)
+	s.Equal("http://a.com/2", sched.pop().URL.String())
+
+	left := sched.drain()
+	s.Len(left, 1)
+	s.Equal(0, sched.len())
+}
+
+func (s *QueueSuite) Test_DeadLetter() {
+	q, err := New(1, nil, MaxRetries(1))
+	s.Nil(err)
+
+	s.Equal(time.Second, ExponentialBackoff(0), "no negative shift")
+	s.Equal(time.Second, ExponentialBackoff(-3))
+	s.Equal(4*time.Second, ExponentialBackoff(3))
+	s.Equal(time.Minute, ExponentialBackoff(100))
+
+	c := roddy.NewCollector()
+
+	u, _ := roddy.ParseUrl("http://example.com/fail")
+	req := &roddy.Request{URL: u}
+
+	var delayed delayedRequests
+
+	q.handleFailure(c, req, errors.New("boom"), &delayed)
+	s.Equal(1, delayed.Len(), "first failure is retried")
+	s.Equal(1, req.Attempts)
+
+	q.handleFailure(c, req, errors.New("boom"), &delayed)
+
+	letters, err := q.DeadLetters()
+	s.Nil(err)
+	s.Len(letters, 1, "moved to dead letters after max retries")
+	s.Equal("boom", letters[0].Error)
+
+	n, err := q.Redrive()
+	s.Nil(err)
+	s.Equal(1, n)
+	s.Equal(1, mustSize(q))
+
+	report := newRunReport()
+
+	redriven, err := q.loadRequest(c, report)
+	s.Nil(err)
+	s.Equal(0, redriven.Attempts)
+	s.Equal(u.String(), redriven.URL.String())
+
+	// numbers beyond float64 precision survive the redrive
+	big := &roddy.Request{URL: u, Depth: 2, Ctx: roddy.NewContext()}
+	big.Ctx.Put("id", int64(1<<53+1))
+	big.Attempts = q.maxRetries
+
+	q.handleFailure(c, big, errors.New("boom"), &delayed)
+
+	n, err = q.Redrive()
+	s.Nil(err)
+	s.Equal(1, n)
+
+	redriven, err = q.loadRequest(c, report)
+	s.Nil(err)
+	s.Equal(0, redriven.Attempts)
+	s.Equal(2, redriven.Depth)
+
+	id, ok := roddy.GetAs[int64](redriven.Ctx, "id")
+	s.True(ok)
+	s.Equal(int64(1<<53+1), id)
+
+	// corrupt entries are quarantined
+	s.Nil(q.storage.AddRequest([]byte("error request")))
+	_, err = q.loadRequest(c, report)
+	s.NotNil(err)
+	s.Equal(1, report.Quarantined)
+
+	size, _ := q.QuarantineStorage().QueueSize()
+	s.Equal(1, size)
+}
+
+func (s *QueueSuite) Test_DeadLetterRun() {
+	var calls atomic.Int32
+
+	// the page of /fail never loads
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/fail" {
+			calls.Add(1)
+		}
+
+		shutdown(w)
+	}))
+	defer server.Close()
+
+	q, err := New(2, nil, MaxRetries(2), RetryBackoff(func(int) time.Duration { return 0 }))
+	s.Nil(err)
+
+	for _, path := range []string{"/ok", "/fail"} {
+		s.Nil(q.AddURL(server.URL + path))
+	}
+
+	// /ok is rejected by the filter
+	c := roddy.NewCollector(roddy.URLFilters(regexp.MustCompile(`/fail`)))
+
+	var lettersErr, redriveErr error
+
+	q.OnRequestDone(func(r *roddy.Request, err error) {
+		if err != nil {
+			_, lettersErr = q.DeadLetters()
+			_, redriveErr = q.Redrive()
+		}
+	})
+
+	report, err := q.Run(c)
+	s.Nil(err)
+	s.ErrorIs(lettersErr, ErrRunning)
+	s.ErrorIs(redriveErr, ErrRunning)
+
+	s.Equal(int32(3), calls.Load(), "first attempt and 2 retries")
+	s.Equal(2, report.Retried)
+	s.Equal(1, report.Failed)
+	s.Equal(1, report.Skipped)
+
+	letters, err := q.DeadLetters()
+	s.Nil(err)
+	s.Len(letters, 1)
+	s.NotEmpty(letters[0].Error)
+	s.Contains(string(letters[0].Request), "/fail")
+
+	letters, _ = q.DeadLetters()
+	s.Len(letters, 1, "dead letters are kept")
+}
+
+func (s *QueueSuite) Test_RedriveFull() {
+	q, err := New(1, NewInMemory(1), MaxRetries(0))
+	s.Nil(err)
+
+	c := roddy.NewCollector()
+
+	var delayed delayedRequests
+
+	for i := 0; i < 3; i++ {
+		u, _ := roddy.ParseUrl(fmt.Sprintf("http://example.com/fail?i=%d", i))
+		q.handleFailure(c, &roddy.Request{URL: u}, errors.New("boom"), &delayed)
+	}
+
+	n, err := q.Redrive()
+	s.ErrorIs(err, roddy.ErrQueueFull)
+	s.Equal(1, n)
+	s.Equal(1, mustSize(q))
+
+	letters, err := q.DeadLetters()
+	s.Nil(err)
+	s.Len(letters, 2, "the dead letter is kept if the queue is full")
+
+	_, err = q.storage.GetRequest()
+	s.Nil(err)
+
+	n, err = q.Redrive()
+	s.ErrorIs(err, roddy.ErrQueueFull)
+	s.Equal(1, n)
+
+	// corrupt dead letters are kept too
+	s.Nil(q.DeadLetterStorage().AddRequest([]byte("error letter")))
+
+	_, err = q.storage.GetRequest()
+	s.Nil(err)
+
+	n, err = q.Redrive()
+	s.NotNil(err)
+	s.Equal(1, n)
+
+	size, _ := q.DeadLetterStorage().QueueSize()
+	s.Equal(1, size)
+	s.Equal(1, mustSize(q))
+}
+
+func (s *QueueSuite) Test_RetryFetch() {
+	var hits atomic.Int32
+
+	// the connection of the first 2 hits is closed, so the page fails to load
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/flaky" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if hits.Add(1) <= 2 {
+			shutdown(w)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	q, err := New(1, nil, MaxRetries(2), RetryBackoff(func(int) time.Duration { return 0 }))
+	s.Nil(err)
+	s.Nil(q.AddURL(server.URL + "/flaky"))
+
+	c := roddy.NewCollector()
+
+	var loaded atomic.Int32
+
+	c.OnResponse(func(r *roddy.Response) {
+		loaded.Add(1)
+	})
+
+	report, err := q.Run(c)
+	s.Nil(err)
+
+	s.Equal(int32(3), hits.Load(), "the visited URL is fetched again by retries")
+	s.Equal(int32(1), loaded.Load())
+	s.Equal(2, report.Retried)
+	s.Equal(1, report.Succeeded)
+	s.Equal(0, report.Skipped)
+}
+
+// hookStorage is a collector storage whose IsVisited is decided by the test,
+// a request fails without a browser if IsVisited returns error.
+type hookStorage struct {
+	*storage.InMemoryStorage
+	isVisited func() (bool, error)
+}
+
+func newHookStorage(isVisited func() (bool, error)) *hookStorage {
+	return &hookStorage{InMemoryStorage: &storage.InMemoryStorage{}, isVisited: isVisited}
+}
+
+func (h *hookStorage) IsVisited(uint64) (bool, error) {
+	return h.isVisited()
+}
+
+// newRejectingCollector rejects every request by URLFilters,
+// so requests are done instantly without a browser.
+func newRejectingCollector() *roddy.Collector {
+	return roddy.NewCollector(roddy.URLFilters(regexp.MustCompile(`^$`)))
+}
+
+func (s *QueueSuite) Test_PauseResume() {
+	q, err := New(2, nil)
+	s.Nil(err)
+
+	for i := 0; i < 10; i++ {
+		s.Nil(q.AddURL(fmt.Sprintf("http://example.com/%d", i)))
+	}
+
+	q.Pause()
+	s.True(q.IsPaused())
+
+	reports := make(chan *RunReport)
+
+	go func() {
+		report, err := q.Run(newRejectingCollector())
+		s.Nil(err)
+		reports <- report
+	}()
+
+	select {
+	case <-reports:
+		s.Fail("paused queue should not return")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	s.Equal(10, mustSize(q), "nothing is taken while paused")
+
+	q.Resume()
+
+	select {
+	case report := <-reports:
+		s.Equal(10, report.Processed)
+		s.Equal(10, report.Skipped)
+	case <-time.After(3 * time.Second):
+		s.Fail("resumed queue should finish")
+	}
+
+	s.True(q.IsEmpty())
+}
+
+func (s *QueueSuite) Test_DrainAndRestart() {
+	q, err := New(2, nil)
+	s.Nil(err)
+
+	for i := 0; i < 5; i++ {
+		s.Nil(q.AddURL(fmt.Sprintf("http://example.com/%d", i)))
+	}
+
+	// drain a queue which is not running is a no-op
+	s.Nil(q.Drain(context.Background()))
+
+	q.Pause()
+
+	reports := make(chan *RunReport)
+
+	go func() {
+		report, err := q.Run(newRejectingCollector())
+		s.Nil(err)
+		reports <- report
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	s.Nil(q.Drain(ctx))
+
+	report := <-reports
+	s.Equal(0, report.Processed)
+	s.Equal(5, mustSize(q), "requests are kept for next run")
+
+	// Run is restartable
+	q.Resume()
+
+	report, err = q.Run(newRejectingCollector())
+	s.Nil(err)
+	s.Equal(5, report.Skipped)
+	s.True(q.IsEmpty())
+}
+
+func (s *QueueSuite) Test_ScheduledRequest() {
+	q, err := New(2, nil)
+	s.Nil(err)
+
+	var done []string
+
+	q.OnRequestDone(func(r *roddy.Request, err error) {
+		done = append(done, r.URL.Path)
+	})
+
+	later, _ := roddy.ParseUrl("http://example.com/later")
+	s.Nil(q.AddRequestAfter(&roddy.Request{URL: later}, 300*time.Millisecond))
+	s.Nil(q.AddURL("http://example.com/now"))
+
+	start := time.Now()
+	report, err := q.Run(newRejectingCollector())
+	s.Nil(err)
+
+	s.Equal(2, report.Processed)
+	s.GreaterOrEqual(time.Since(start), 300*time.Millisecond, "wait for the scheduled request")
+	s.Equal([]string{"/now", "/later"}, done)
+
+	// NotBefore survives the storage
+	q.AddRequestAt(&roddy.Request{URL: later}, start.Add(time.Hour))
+
+	r, err := q.loadRequest(newRejectingCollector(), newRunReport())
+	s.Nil(err)
+	s.True(r.NotBefore.Equal(start.Add(time.Hour)))
+}
+
+func (s *QueueSuite) Test_ScheduledCrash() {
+	path := filepath.Join(s.T().TempDir(), "queue.db")
+
+	db, err := boltstorage.Open(path)
+	s.Require().Nil(err)
+
+	q, err := New(1, db.Storage("queue"))
+	s.Nil(err)
+
+	later, _ := roddy.ParseUrl("http://example.com/later")
+	at := time.Now().Add(time.Hour)
+	s.Nil(q.AddRequestAt(&roddy.Request{URL: later}, at))
+
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+		q.Run(newRejectingCollector())
+	}()
+
+	s.Eventually(func() bool { return mustSize(q) == 0 }, time.Second, 10*time.Millisecond, "loaded and waiting")
+
+	// crash: the file is copied while Run holds the scheduled request
+	buf, err := os.ReadFile(path)
+	s.Require().Nil(err)
+
+	crashed := filepath.Join(s.T().TempDir(), "queue.db")
+	s.Require().Nil(os.WriteFile(crashed, buf, 0o644))
+
+	q.Stop()
+	<-finished
+	s.Nil(db.Close())
+
+	db, err = boltstorage.Open(crashed)
+	s.Require().Nil(err)
+	defer db.Close()
+
+	q, err = New(1, db.Storage("queue"))
+	s.Nil(err)
+	s.Equal(1, mustSize(q), "scheduled request survives the crash")
+
+	r, err := q.loadRequest(newRejectingCollector(), newRunReport())
+	s.Nil(err)
+	s.Equal("/later", r.URL.Path)
+	s.True(r.NotBefore.Equal(at))
+}
+
+func (s *QueueSuite) Test_Seeds() {
+	q, err := New(1, nil)
+	s.Nil(err)
+
+	ctx := roddy.NewContext()
+	ctx.Put("category", "books")
+	s.Nil(q.AddURLWithContext("http://example.com/books", 2, ctx))
+
+	seeds := `
+# comment
+http://example.com/plain
+
+{"url": "http://example.com/json", "depth": 3, "priority": 5, "ctx": {"category": "music"}}
+`
+	n, err := q.AddSeeds(strings.NewReader(seeds))
+	s.Nil(err)
+	s.Equal(2, n)
+
+	c := newRejectingCollector()
+	report := newRunReport()
+
+	want := []struct {
+		path     string
+		depth    int
+		priority int
+		category string
+	}{
+		{"/books", 2, 0, "books"},
+		{"/plain", 1, 0, ""},
+		{"/json", 3, 5, "music"},
+	}
+
+	for _, w := range want {
+		r, err := q.loadRequest(c, report)
+		s.Nil(err)
+		s.Equal(w.path, r.URL.Path)
+		s.Equal(w.depth, r.Depth)
+		s.Equal(w.priority, r.Priority)
+		s.Equal(w.category, r.Ctx.Get("category"))
+	}
+
+	_, err = q.AddSeeds(strings.NewReader("http://example.com/ok\n{invalid"))
+	s.ErrorContains(err, "seed line 2")
+	s.True(q.IsEmpty(), "nothing is added on invalid seeds")
+}
+
+func (s *QueueSuite) Test_Sitemap() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<sitemapindex><sitemap><loc>/500</loc></sitemap><sitemap><loc>/pages.xml</loc></sitemap></sitemapindex>`))
+	})
+	mux.HandleFunc("/pages.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<urlset><url><loc>/a</loc></url><url><loc>/b</loc></url></urlset>`))
+	})
+	mux.HandleFunc("/500", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	q, err := New(1, nil)
+	s.Nil(err)
+
+	n, err := q.AddSitemap(roddy.NewCollector(), server.URL+"/sitemap.xml")
+	s.ErrorContains(err, "/500")
+	s.Equal(2, n, "the entries of the other sitemaps are added")
+	s.Equal(2, mustSize(q))
+}
+
+func (s *QueueSuite) Test_StorageError() {
+	st := &brokenSizeStorage{InMemoryQueueStorage: NewInMemory(0)}
+
+	q, err := New(2, st)
+	s.Nil(err)
+
+	s.Nil(q.AddURL("http://example.com/active"))
+
+	var done []string
+
+	q.OnRequestDone(func(r *roddy.Request, err error) {
+		done = append(done, r.URL.Path)
+	})
+
+	report, err := q.Run(newRejectingCollector())
+	s.ErrorContains(err, "size unavailable")
+	s.Equal(1, report.Skipped, "the active request is collected before Run returns")
+	s.Equal([]string{"/active"}, done)
+}
+
+// brokenSizeStorage fails QueueSize once a request is popped
+type brokenSizeStorage struct {
+	*InMemoryQueueStorage
+	popped atomic.Bool
+}
+
+func (b *brokenSizeStorage) GetRequest() ([]byte, error) {
+	b.popped.Store(true)
+	return b.InMemoryQueueStorage.GetRequest()
+}
+
+func (b *brokenSizeStorage) QueueSize() (int, error) {
+	if b.popped.Load() {
+		return 0, errors.New("size unavailable")
+	}
+
+	return b.InMemoryQueueStorage.QueueSize()
+}
+
+func mustSize(q *Queue) int {
+	size, err := q.Size()
+	if err != nil {
+		panic(err)
+	}
+
+	return size
+}
+
 func serverHandler(w http.ResponseWriter, req *http.Request) {
 	if !serverRoute(w, req) {
 		shutdown(w)
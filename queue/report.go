@@ -0,0 +1,107 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"roddy"
+)
+
+// RunReport is the summary of a Queue.Run
+type RunReport struct {
+	// Processed is the number of requests with a final outcome
+	Processed int
+	Succeeded int
+	// Failed is the number of requests moved to dead letters
+	Failed  int
+	Skipped int
+	// Retried is the number of failed attempts which are re-enqueued
+	Retried int
+	// Quarantined is the number of corrupt queue entries
+	Quarantined int
+
+	// Errors groups the errors of failed requests by type
+	Errors map[string][]error
+
+	Duration time.Duration
+}
+
+// RequestDoneCallback is called when a request gets its final outcome
+type RequestDoneCallback func(*roddy.Request, error)
+
+func newRunReport() *RunReport {
+	return &RunReport{Errors: make(map[string][]error)}
+}
+
+// String is the one-line summary of the report
+func (r *RunReport) String() string {
+	return fmt.Sprintf(
+		"processed: %d (succeeded: %d, failed: %d, skipped: %d) | retried: %d, quarantined: %d | %s",
+		r.Processed, r.Succeeded, r.Failed, r.Skipped, r.Retried, r.Quarantined, r.Duration,
+	)
+}
+
+// OnRequestDone registers a function, it's called when a request succeeded,
+// skipped or finally failed, retried attempts are not reported.
+func (q *Queue) OnRequestDone(f RequestDoneCallback) {
+	q.mut.Lock()
+	q.doneCallbacks = append(q.doneCallbacks, f)
+	q.mut.Unlock()
+}
+
+func (q *Queue) handleOnRequestDone(req *roddy.Request, err error) {
+	q.mut.Lock()
+	callbacks := q.doneCallbacks
+	q.mut.Unlock()
+
+	for _, f := range callbacks {
+		f(req, err)
+	}
+}
+
+func (r *RunReport) addSucceeded() {
+	r.Processed++
+	r.Succeeded++
+}
+
+func (r *RunReport) addSkipped() {
+	r.Processed++
+	r.Skipped++
+}
+
+func (r *RunReport) addRetried() {
+	r.Retried++
+}
+
+func (r *RunReport) addQuarantined() {
+	r.Quarantined++
+}
+
+func (r *RunReport) addFailed(err error) {
+	r.Processed++
+	r.Failed++
+
+	kind := errorKind(err)
+	r.Errors[kind] = append(r.Errors[kind], err)
+}
+
+// errorKind is the type name of the innermost error,
+// or the message for plain errors created by errors.New.
+func errorKind(err error) string {
+	for {
+		inner := errors.Unwrap(err)
+		if inner == nil {
+			break
+		}
+
+		err = inner
+	}
+
+	kind := fmt.Sprintf("%T", err)
+	if kind == "*errors.errorString" {
+		return err.Error()
+	}
+
+	return kind
+}
@@ -0,0 +1,318 @@
+package queue
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"roddy"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	_defaultMaxRetries = 3
+	_maxBackoff        = time.Minute
+)
+
+// ErrRunning is returned by the operations which cannot run with Queue.Run
+var ErrRunning = errors.New("Queue is running")
+
+// DeadLetter is a request which still fails after MaxRetries retries
+type DeadLetter struct {
+	// Request is the serialized request
+	Request json.RawMessage
+	// Error is the error of the last attempt
+	Error string
+	Time  time.Time
+}
+
+// BackoffFunc returns the delay before the attempt-th retry
+type BackoffFunc func(attempt int) time.Duration
+
+// ExponentialBackoff waits 1s, 2s, 4s ... at most 1 minute.
+func ExponentialBackoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	if attempt > 6 {
+		return _maxBackoff
+	}
+
+	return min(time.Second<<(attempt-1), _maxBackoff)
+}
+
+// MaxRetries sets how many times a failed request is re-enqueued
+// before it's moved to the dead-letter storage. Set it to 0 to disable retry.
+func MaxRetries(n int) Option {
+	return func(q *Queue) {
+		q.maxRetries = n
+	}
+}
+
+// RetryBackoff sets the delay before each retry, ExponentialBackoff by default.
+func RetryBackoff(fn BackoffFunc) Option {
+	return func(q *Queue) {
+		q.backoff = fn
+	}
+}
+
+// DeadLetterStorage sets where the failed requests are moved,
+// an unlimited in-memory storage by default.
+func DeadLetterStorage(s Storage) Option {
+	return func(q *Queue) {
+		q.deadLetters = s
+	}
+}
+
+// QuarantineStorage sets where the corrupt queue entries are moved,
+// an unlimited in-memory storage by default.
+func QuarantineStorage(s Storage) Option {
+	return func(q *Queue) {
+		q.quarantine = s
+	}
+}
+
+// DeadLetterStorage returns the storage of dead letters, each entry is a JSON encoded DeadLetter.
+func (q *Queue) DeadLetterStorage() Storage {
+	return q.deadLetters
+}
+
+// QuarantineStorage returns the storage of corrupt entries, each entry is stored as it was.
+func (q *Queue) QuarantineStorage() Storage {
+	return q.quarantine
+}
+
+// DeadLetters returns all dead letters without removing them. Storage has no way
+// to peek, so each entry is popped and added back, it's only for inspecting a stopped
+// queue, and ErrRunning is returned while Run is in progress.
+func (q *Queue) DeadLetters() ([]*DeadLetter, error) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	if q.running {
+		return nil, ErrRunning
+	}
+
+	size, err := q.deadLetters.QueueSize()
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]*DeadLetter, 0, size)
+
+	for i := 0; i < size; i++ {
+		buf, err := q.deadLetters.GetRequest()
+		if err != nil {
+			return letters, err
+		}
+
+		if buf == nil {
+			break
+		}
+
+		// put it back to keep the dead letters
+		if err := q.deadLetters.AddRequest(buf); err != nil {
+			return letters, err
+		}
+
+		dl := &DeadLetter{}
+		if err := json.Unmarshal(buf, dl); err != nil {
+			return letters, err
+		}
+
+		letters = append(letters, dl)
+	}
+
+	return letters, nil
+}
+
+// Redrive moves all dead letters back to the queue with their attempts reset,
+// it returns the number of moved requests. A dead letter which cannot be moved is
+// kept, and ErrRunning is returned while Run is in progress.
+func (q *Queue) Redrive() (int, error) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	if q.running {
+		return 0, ErrRunning
+	}
+
+	size, err := q.deadLetters.QueueSize()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+
+	for i := 0; i < size; i++ {
+		buf, err := q.deadLetters.GetRequest()
+		if err != nil {
+			return n, err
+		}
+
+		if buf == nil {
+			break
+		}
+
+		if err := q.redrive(buf); err != nil {
+			// put it back to keep the dead letter
+			if aerr := q.deadLetters.AddRequest(buf); aerr != nil {
+				log.Error().Err(aerr).Msg("cannot keep dead letter")
+			}
+
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, nil
+}
+
+// redrive adds the request of the dead letter buf to the queue with its attempts reset
+func (q *Queue) redrive(buf []byte) error {
+	dl := &DeadLetter{}
+	if err := json.Unmarshal(buf, dl); err != nil {
+		return err
+	}
+
+	// keep the other fields as they are, numbers decoded as float64 would lose precision
+	req := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(dl.Request, &req); err != nil {
+		return err
+	}
+
+	req["Attempts"] = json.RawMessage("0")
+
+	raw, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	return q.storage.AddRequest(raw)
+}
+
+// handleFailure re-enqueues failed request with backoff, or moves it to the dead-letter storage,
+// it returns true if the request is retried.
+func (q *Queue) handleFailure(c *roddy.Collector, req *roddy.Request, err error, delayed *delayedRequests) bool {
+	req.Attempts++
+
+	if req.Attempts <= q.maxRetries {
+		delay := q.backoff(req.Attempts)
+		req.NotBefore = time.Now().Add(delay)
+		delayed.push(req, req.NotBefore)
+
+		c.Emit(roddy.EventRetried, req, err)
+		log.Debug().Err(err).Int("attempts", req.Attempts).Dur("delay", delay).Str("url", req.URL.String()).Msg("retry")
+
+		return true
+	}
+
+	buf, merr := req.Marshal()
+	if merr != nil {
+		log.Error().Err(merr).Str("url", req.URL.String()).Msg("cannot marshal dead letter")
+		return false
+	}
+
+	raw, merr := json.Marshal(&DeadLetter{
+		Request: buf,
+		Error:   err.Error(),
+		Time:    time.Now(),
+	})
+	if merr != nil {
+		log.Error().Err(merr).Str("url", req.URL.String()).Msg("cannot marshal dead letter")
+		return false
+	}
+
+	if err := q.deadLetters.AddRequest(raw); err != nil {
+		log.Error().Err(err).Str("url", req.URL.String()).Msg("cannot add dead letter")
+	}
+
+	return false
+}
+
+func (q *Queue) quarantineEntry(buf []byte, err error) {
+	log.Warn().Err(err).Int("bytes", len(buf)).Msg("quarantine corrupt queue entry")
+
+	if err := q.quarantine.AddRequest(buf); err != nil {
+		log.Error().Err(err).Msg("cannot quarantine queue entry")
+	}
+}
+
+// delayedRequests holds the requests waiting for their due time, ordered by due time.
+// It holds both retries and scheduled requests, as both are Request.NotBefore in the future.
+type delayedRequests []*delayedRequest
+
+type delayedRequest struct {
+	req *roddy.Request
+	due time.Time
+}
+
+func (d *delayedRequests) push(r *roddy.Request, due time.Time) {
+	heap.Push(d, &delayedRequest{req: r, due: due})
+}
+
+// hold keeps the request if its NotBefore is in the future, returns true if held.
+func (d *delayedRequests) hold(r *roddy.Request) bool {
+	if !r.NotBefore.After(time.Now()) {
+		return false
+	}
+
+	d.push(r, r.NotBefore)
+
+	return true
+}
+
+// next returns the earliest due time
+func (d delayedRequests) next() (time.Time, bool) {
+	if len(d) == 0 {
+		return time.Time{}, false
+	}
+
+	return d[0].due, true
+}
+
+// popDue pops requests whose due time is before now
+func (d *delayedRequests) popDue(now time.Time) []*roddy.Request {
+	var reqs []*roddy.Request
+
+	for len(*d) > 0 && !(*d)[0].due.After(now) {
+		reqs = append(reqs, heap.Pop(d).(*delayedRequest).req)
+	}
+
+	return reqs
+}
+
+func (d *delayedRequests) popAll() []*roddy.Request {
+	reqs := make([]*roddy.Request, 0, len(*d))
+	for _, v := range *d {
+		reqs = append(reqs, v.req)
+	}
+
+	*d = nil
+
+	return reqs
+}
+
+func (d delayedRequests) Len() int { return len(d) }
+
+func (d delayedRequests) Less(i, j int) bool { return d[i].due.Before(d[j].due) }
+
+func (d delayedRequests) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+
+func (d *delayedRequests) Push(x any) {
+	*d = append(*d, x.(*delayedRequest))
+}
+
+func (d *delayedRequests) Pop() any {
+	old := *d
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*d = old[:n-1]
+
+	return item
+}
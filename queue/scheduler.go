@@ -0,0 +1,160 @@
+package queue
+
+import (
+	"roddy"
+)
+
+const _defaultHostBuffer = 1024
+
+// hostScheduler buffers loaded requests in per-host sub-queues,
+// and serves the hosts in weighted round-robin order.
+type hostScheduler struct {
+	// limit is the max concurrent requests of a host, 0 means no limit
+	limit   int
+	weights map[string]int
+
+	hosts map[string]*hostQueue
+	// ring is the round-robin order of hosts
+	ring     []string
+	cursor   int
+	buffered int
+}
+
+type hostQueue struct {
+	pending []*roddy.Request
+	active  int
+	// credit is the number of requests the host can still take in current round
+	credit int
+}
+
+func newHostScheduler(limit int, weights map[string]int) *hostScheduler {
+	return &hostScheduler{
+		limit:   limit,
+		weights: weights,
+		hosts:   make(map[string]*hostQueue),
+	}
+}
+
+func (s *hostScheduler) len() int {
+	return s.buffered
+}
+
+func (s *hostScheduler) push(r *roddy.Request) {
+	host := requestHost(r)
+
+	h, ok := s.hosts[host]
+	if !ok {
+		h = &hostQueue{credit: s.weight(host)}
+		s.hosts[host] = h
+		s.ring = append(s.ring, host)
+	}
+
+	h.pending = append(h.pending, r)
+	s.buffered++
+}
+
+// pop returns the next request of the hosts which are not at their limit,
+// nil if no such request.
+func (s *hostScheduler) pop() *roddy.Request {
+	for i := 0; i < len(s.ring); i++ {
+		host := s.ring[s.cursor]
+		h := s.hosts[host]
+
+		if len(h.pending) == 0 || (s.limit > 0 && h.active >= s.limit) {
+			s.advance(h, host)
+			continue
+		}
+
+		r := h.pending[0]
+		h.pending[0] = nil
+		h.pending = h.pending[1:]
+		h.active++
+		s.buffered--
+
+		h.credit--
+		if h.credit <= 0 {
+			s.advance(h, host)
+		}
+
+		return r
+	}
+
+	return nil
+}
+
+// done releases the concurrency slot of request's host.
+func (s *hostScheduler) done(r *roddy.Request) {
+	host := requestHost(r)
+
+	h, ok := s.hosts[host]
+	if !ok {
+		return
+	}
+
+	h.active--
+	s.cleanup(host)
+}
+
+// drain removes and returns all buffered requests.
+func (s *hostScheduler) drain() []*roddy.Request {
+	var reqs []*roddy.Request
+
+	for _, host := range s.ring {
+		h := s.hosts[host]
+		reqs = append(reqs, h.pending...)
+		h.pending = nil
+	}
+
+	s.buffered = 0
+
+	return reqs
+}
+
+// advance moves the cursor to next host, and refills the credit of current host.
+func (s *hostScheduler) advance(h *hostQueue, host string) {
+	h.credit = s.weight(host)
+	s.cursor = (s.cursor + 1) % len(s.ring)
+}
+
+// cleanup removes idle host from the ring to keep it small.
+func (s *hostScheduler) cleanup(host string) {
+	h := s.hosts[host]
+	if len(h.pending) != 0 || h.active > 0 {
+		return
+	}
+
+	delete(s.hosts, host)
+
+	for i, v := range s.ring {
+		if v != host {
+			continue
+		}
+
+		s.ring = append(s.ring[:i], s.ring[i+1:]...)
+		if i < s.cursor {
+			s.cursor--
+		}
+
+		break
+	}
+
+	if s.cursor >= len(s.ring) {
+		s.cursor = 0
+	}
+}
+
+func (s *hostScheduler) weight(host string) int {
+	if w, ok := s.weights[host]; ok && w > 0 {
+		return w
+	}
+
+	return 1
+}
+
+func requestHost(r *roddy.Request) string {
+	if r.URL == nil {
+		return ""
+	}
+
+	return r.URL.Host
+}
@@ -0,0 +1,127 @@
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"roddy"
+)
+
+// Seed is a line of the seed file in JSON form, keys are matched case-insensitively.
+// Lines of Request.Marshal are accepted, but only their URL, Depth, Priority and Ctx are kept,
+// Attempts and NotBefore are dropped, as a seed is a fresh request.
+//
+//	{"url": "https://example.com/", "depth": 1, "priority": 0, "ctx": {"category": "books"}}
+type Seed struct {
+	URL string `json:"url"`
+	// Depth is 1 if omitted
+	Depth    int            `json:"depth"`
+	Priority int            `json:"priority"`
+	Ctx      *roddy.Context `json:"ctx"`
+}
+
+// Request creates the Request of seed.
+func (s *Seed) Request() (*roddy.Request, error) {
+	depth := s.Depth
+	if depth == 0 {
+		depth = 1
+	}
+
+	r, err := newRequest(s.URL, depth, s.Ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Priority = s.Priority
+
+	return r, nil
+}
+
+// AddSeedFile adds the requests of the seed file at path, see AddSeeds for the format.
+func (q *Queue) AddSeedFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return q.AddSeeds(f)
+}
+
+// AddSeeds adds the requests read from r, one seed per line:
+//   - a line starting with "{" is a JSON encoded Seed
+//   - other lines are plain URLs of depth 1
+//   - blank lines and lines starting with "#" are skipped
+//
+// It stops at the first invalid line, and returns the number of added requests.
+func (q *Queue) AddSeeds(r io.Reader) (int, error) {
+	var reqs []*roddy.Request
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for ln := 1; scanner.Scan(); ln++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		req, err := parseSeed(line)
+		if err != nil {
+			return 0, fmt.Errorf("seed line %d: %w", ln, err)
+		}
+
+		reqs = append(reqs, req)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return q.AddRequests(reqs)
+}
+
+func parseSeed(line []byte) (*roddy.Request, error) {
+	if line[0] != '{' {
+		return newRequest(string(line), 1, nil)
+	}
+
+	seed := &Seed{}
+	if err := json.Unmarshal(line, seed); err != nil {
+		return nil, err
+	}
+
+	return seed.Request()
+}
+
+// AddSitemap adds the entries of the sitemap at URL with their <lastmod> in Ctx,
+// see roddy.Collector.FetchSitemap for the accepted URLs and options. The entries
+// fetched are added even if some sitemaps fail, and their errors are returned joined.
+func (q *Queue) AddSitemap(c *roddy.Collector, URL string, opts ...roddy.SitemapOption) (int, error) {
+	entries, err := c.FetchSitemap(URL, opts...)
+	if err != nil && len(entries) == 0 {
+		return 0, err
+	}
+
+	errs := []error{err}
+	reqs := make([]*roddy.Request, 0, len(entries))
+
+	for _, e := range entries {
+		req, err := newRequest(e.Loc, 1, e.Context())
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		reqs = append(reqs, req)
+	}
+
+	n, err := q.AddRequests(reqs)
+
+	return n, errors.Join(append(errs, err)...)
+}
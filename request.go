@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/coghost/xbot"
 	"github.com/go-rod/rod"
@@ -26,8 +27,17 @@ type Request struct {
 	Ctx *Context
 	// Depth is the number of the parents of the request
 	Depth int
+	// Priority is used by priority-aware queue storages,
+	// requests with higher priority are consumed first.
+	Priority int
+	// Attempts is the number of failed attempts, it's counted by queue retries.
+	Attempts int
+	// NotBefore is the earliest time the request can be consumed by queue.
+	NotBefore time.Time
 
 	abort bool
+	// paging is the pagination chain of a turned page, it's not inherited by children
+	paging *paging
 
 	baseURL   *url.URL
 	collector *Collector
@@ -36,10 +46,13 @@ type Request struct {
 }
 
 type serializableRequest struct {
-	ID    uint32
-	URL   string
-	Depth int
-	Ctx   map[string]interface{}
+	ID        uint32
+	URL       string
+	Depth     int
+	Priority  int
+	Attempts  int
+	NotBefore time.Time
+	Ctx       *Context
 }
 
 var urlParser = whatwgUrl.NewParser(whatwgUrl.WithPercentEncodeSinglePercentSign())
@@ -52,14 +65,21 @@ func (r *Request) New(URL string) (*Request, error) {
 
 	return &Request{
 		URL:       u2,
-		Ctx:       r.Ctx,
+		Ctx:       r.Ctx.Child(),
 		ID:        atomic.AddUint32(&r.collector.requestCount, 1),
 		collector: r.collector,
 	}, nil
 }
 
+// AbsoluteURL returns the absolute URL of u, canonicalized if the collector has a Canonicalizer.
+// Fragment only references are ignored, unless they are hash routes kept by the Canonicalizer.
 func (r *Request) AbsoluteURL(u string) string {
-	if strings.HasPrefix(u, "#") {
+	var cz *Canonicalizer
+	if r.collector != nil {
+		cz = r.collector.canonicalizer
+	}
+
+	if strings.HasPrefix(u, "#") && (cz == nil || !cz.keepFragment(r.URL.Hostname(), u[1:])) {
 		return ""
 	}
 
@@ -75,6 +95,10 @@ func (r *Request) AbsoluteURL(u string) string {
 		return ""
 	}
 
+	if cz != nil {
+		return cz.Canonicalize(absURL.Href(false))
+	}
+
 	return absURL.Href(false)
 }
 
@@ -92,33 +116,29 @@ func (r *Request) String() string {
 }
 
 func (r *Request) Visit(URL string) error {
-	return r.collector.scrape(URL, r.Depth+1, r.Ctx)
+	return r.collector.scrape(URL, r.Depth+1, r.Ctx.Child())
 }
 
 func (r *Request) VisitByMockClick() error {
-	return r.collector.scrape(BlankPagePlaceholder, r.Depth, r.Ctx)
+	return r.collector.scrape(BlankPagePlaceholder, r.Depth, r.Ctx.Child())
 }
 
+// Do scrapes the request, a request with failed attempts is scraped again
+// even if its URL is visited.
 func (r *Request) Do() error {
-	return r.collector.scrape(r.URL.String(), r.Depth, r.Ctx)
+	return r.collector.scrapeRequest(r.URL.String(), r)
 }
 
 // Marshal serializes the Request
 func (r *Request) Marshal() ([]byte, error) {
-	ctx := make(map[string]interface{})
-
-	if r.Ctx != nil {
-		r.Ctx.ForEach(func(k string, v interface{}) interface{} {
-			ctx[k] = v
-			return nil
-		})
-	}
-
 	req := &serializableRequest{
-		URL:   r.URL.String(),
-		Depth: r.Depth,
-		Ctx:   ctx,
-		ID:    r.ID,
+		URL:       r.URL.String(),
+		Depth:     r.Depth,
+		Priority:  r.Priority,
+		Attempts:  r.Attempts,
+		NotBefore: r.NotBefore,
+		Ctx:       r.Ctx,
+		ID:        r.ID,
 	}
 
 	return json.Marshal(req)
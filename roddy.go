@@ -20,14 +20,11 @@ import (
 	"github.com/PuerkitoBio/goquery"
 	"github.com/coghost/xbot"
 	"github.com/coghost/xutil"
-	"github.com/go-rod/rod"
 	"github.com/rs/zerolog/log"
 )
 
 const (
-	_capacity = 4
-
-	_waitGroupSize = 4
+	_selectorPollInterval = 200 * time.Millisecond
 )
 
 var collectorCounter uint32
@@ -43,6 +40,8 @@ func (c *Collector) Init() {
 	c.store = &storage.InMemoryStorage{}
 	c.store.Init()
 
+	c.contentIndex = newContentIndex()
+
 	c.highlightCount = 2
 	c.highlightStyle = `box-shadow: 0 0 10px rgba(255,125,0,1), 0 0 20px 5px rgba(255,175,0,0.8), 0 0 30px 15px rgba(255,225,0,0.5);`
 
@@ -51,7 +50,6 @@ func (c *Collector) Init() {
 	c.cacheDir = c.baseDir + "/cache"
 
 	c.wg = &sync.WaitGroup{}
-	c.lock = &sync.RWMutex{}
 	c.ctx = context.Background()
 }
 
@@ -72,10 +70,10 @@ func (c *Collector) String() string {
 		"Requests made: %d (%d responses) | Callbacks: OnRequest: %d, OnHTML: %d, OnResponse: %d, OnError: %d",
 		atomic.LoadUint32(&c.requestCount),
 		atomic.LoadUint32(&c.responseCount),
-		len(c.requestCallbacks),
-		len(c.dataCallbacks),
-		len(c.responseCallbacks),
-		len(c.errorCallbacks),
+		c.requestCallbacks.len(),
+		c.dataCallbacks.len(),
+		c.responseCallbacks.len(),
+		c.errorCallbacks.len(),
 	)
 }
 
@@ -122,6 +120,12 @@ func (c *Collector) Visit(URL string) error {
 }
 
 func (c *Collector) getParsedURL(u string, depth int) (*url.URL, error) {
+	return c.parseRequestURL(u, depth, false)
+}
+
+// parseRequestURL parses and checks u, a retry skips the visited check
+// as its URL was marked visited by the failed attempt.
+func (c *Collector) parseRequestURL(u string, depth int, retry bool) (*url.URL, error) {
 	if u == BlankPagePlaceholder {
 		return nil, nil
 	}
@@ -131,7 +135,8 @@ func (c *Collector) getParsedURL(u string, depth int) (*url.URL, error) {
 		return nil, err
 	}
 
-	if err := c.requestCheck(parsedURL, depth); err != nil {
+	if err := c.requestCheck(parsedURL, depth, retry); err != nil {
+		c.emitURL(EventSkipped, parsedURL.String(), depth, err)
 		err = c.handleIgnoredErrors(err)
 		return nil, err
 	}
@@ -140,20 +145,39 @@ func (c *Collector) getParsedURL(u string, depth int) (*url.URL, error) {
 }
 
 func (c *Collector) scrape(u string, depth int, ctx *Context) error {
-	parsedURL, err := c.getParsedURL(u, depth)
+	return c.scrapeRequest(u, &Request{Depth: depth, Ctx: ctx})
+}
+
+// scrapeRequest scrapes u with the Depth, Ctx and paging of seed,
+// a seed with failed attempts is a retry of the queue.
+func (c *Collector) scrapeRequest(u string, seed *Request) error {
+	depth := seed.Depth
+
+	parsedURL, err := c.parseRequestURL(u, depth, seed.Attempts > 0)
 	if err != nil {
 		return err
 	}
 
+	rid := atomic.AddUint32(&c.requestCount, 1)
+
+	if c.eventSink != nil {
+		ev := &Event{Type: EventRequestQueued, RequestID: rid, Depth: depth}
+		if parsedURL != nil {
+			ev.URL = parsedURL.String()
+		}
+
+		c.emit(ev)
+	}
+
 	if c.async {
 		c.wg.Add(1)
-		return c.asyncFetch(parsedURL, depth, ctx)
+		return c.asyncFetch(rid, parsedURL, seed)
 	}
 
-	return c.fetch(parsedURL, depth, ctx)
+	return c.fetch(rid, parsedURL, seed)
 }
 
-func (c *Collector) asyncFetch(parsedURL *url.URL, depth int, ctx *Context) error {
+func (c *Collector) asyncFetch(rid uint32, parsedURL *url.URL, seed *Request) error {
 	errChan := make(chan error, 1)
 
 	go func() {
@@ -165,7 +189,7 @@ func (c *Collector) asyncFetch(parsedURL *url.URL, depth int, ctx *Context) erro
 			<-c.waitChan
 		}(c)
 
-		err := c.fetch(parsedURL, depth, ctx)
+		err := c.fetch(rid, parsedURL, seed)
 		err = c.handleIgnoredErrors(err)
 
 		if err != nil {
@@ -182,19 +206,20 @@ func (c *Collector) asyncFetch(parsedURL *url.URL, depth int, ctx *Context) erro
 	}
 }
 
-func (c *Collector) fetch(URL *url.URL, depth int, ctx *Context) error {
+func (c *Collector) fetch(rid uint32, URL *url.URL, seed *Request) error {
 	bot, page := c.createPage()
 
+	ctx := seed.Ctx
 	if ctx == nil {
 		ctx = NewContext()
 	}
 
-	rid := atomic.AddUint32(&c.requestCount, 1)
 	request := &Request{
-		ID:    rid,
-		URL:   URL,
-		Ctx:   ctx,
-		Depth: depth,
+		ID:     rid,
+		URL:    URL,
+		Ctx:    ctx,
+		Depth:  seed.Depth,
+		paging: seed.paging,
 
 		collector: c,
 		bot:       bot,
@@ -207,47 +232,107 @@ func (c *Collector) fetch(URL *url.URL, depth int, ctx *Context) error {
 		return nil
 	}
 
-	response, err := c.MustGet(request, page, URL, depth)
+	c.emitRequest(EventRequestStarted, request, nil)
+
+	response, err := c.handler()(request)
 	if err != nil {
 		return c.handleOnError(nil, err, request, ctx)
 	}
 
+	if response == nil {
+		// dropped by middleware
+		return nil
+	}
+
 	atomic.AddUint32(&c.responseCount, 1)
 
+	if response.Request == nil {
+		response.Request = request
+	}
+
 	response.Ctx = ctx
 
+	if response.Page == nil {
+		// short-circuited by middleware without page, nothing to run the page callbacks on
+		c.handleOnResponse(response)
+		c.handleOnScraped(response)
+
+		return nil
+	}
+
+	c.markRelCanonical(response)
+
+	if err := c.checkDuplicateContent(response); err != nil {
+		return err
+	}
+
 	c.handleOnResponse(response)
 
-	err = c.handleOnHTML(response)
+	return c.handlePage(response)
+}
+
+// handlePage runs the callbacks of a loaded page, and turns it to the next page by paginators.
+func (c *Collector) handlePage(response *Response) error {
+	request, ctx := response.Request, response.Ctx
+
+	paginators, chain, page := c.pagedPaginators(request)
+
+	err := c.handlePageCallbacks(response, paginators, chain, page)
 	if err != nil {
+		for _, p := range paginators {
+			p.end(chain, "error")
+		}
+
+		if errors.Is(err, ErrMaxResponses) || errors.Is(err, ErrMaxPageNumReached) {
+			return err
+		}
+
 		return c.handleOnError(response, err, request, ctx)
 	}
 
-	err = c.handleOnData(response)
-	if err != nil {
-		return c.handleOnError(response, err, request, ctx)
+	return nil
+}
+
+func (c *Collector) handlePageCallbacks(response *Response, paginators []*paginatorContainer, chain uint32, page int) error {
+	ok, err := c.loadPages(response, paginators, chain, page)
+	if err != nil || !ok {
+		return err
+	}
+
+	if err := c.handleOnHTML(response); err != nil {
+		return err
+	}
+
+	if err := c.handleOnData(response); err != nil {
+		return err
+	}
+
+	if err := c.handleFollowLinks(response); err != nil {
+		return err
 	}
 
 	if c.maxResponses > 0 && c.responseCount >= c.maxResponses {
 		return ErrMaxResponses
 	}
 
-	err = c.handleMaxPageNum()
-	if err != nil {
+	if err := c.handleMaxPageNum(); err != nil {
 		return err
 	}
 
-	err = c.handleOnPaging(response)
-	if err != nil {
-		return c.handleOnError(response, err, request, ctx)
+	if err := c.handleOnPaging(response); err != nil {
+		return err
+	}
+
+	if err := c.turnPages(response, paginators, chain, page); err != nil {
+		return err
 	}
 
 	c.handleOnScraped(response)
 
-	return err
+	return nil
 }
 
-func (c *Collector) requestCheck(parsedURL *url.URL, depth int) error {
+func (c *Collector) requestCheck(parsedURL *url.URL, depth int, retry bool) error {
 	if c.maxDepth > 0 && c.maxDepth < depth {
 		return ErrMaxDepth
 	}
@@ -256,10 +341,14 @@ func (c *Collector) requestCheck(parsedURL *url.URL, depth int) error {
 		return ErrMaxRequests
 	}
 
-	if err := c.checkFilters(parsedURL, parsedURL.Hostname()); err != nil {
+	if err := c.checkFilters(parsedURL); err != nil {
 		return err
 	}
 
+	if retry {
+		return nil
+	}
+
 	if err := c.checkVistedStatus(parsedURL); err != nil {
 		return err
 	}
@@ -267,7 +356,7 @@ func (c *Collector) requestCheck(parsedURL *url.URL, depth int) error {
 	return nil
 }
 
-func (c *Collector) checkFilters(parsedURL *url.URL, domain string) error {
+func (c *Collector) checkFilters(parsedURL *url.URL) error {
 	u := parsedURL.String()
 
 	if len(c.disallowedURLFilters) > 0 {
@@ -282,7 +371,7 @@ func (c *Collector) checkFilters(parsedURL *url.URL, domain string) error {
 		}
 	}
 
-	if !c.isDomainAllowed(domain) {
+	if !c.isDomainAllowed(parsedURL) {
 		return ErrForbiddenDomain
 	}
 
@@ -294,9 +383,15 @@ func (c *Collector) checkVistedStatus(parsedURL *url.URL) error {
 		return nil
 	}
 
-	u := parsedURL.String()
+	u := c.canonicalURL(parsedURL.String())
 	uHash := requestHash(u, nil)
 
+	if ttl := c.revisitTTL(u); ttl > 0 {
+		if ts, ok := c.store.(storage.VisitTimeStorage); ok {
+			return c.checkVisitTime(ts, parsedURL, uHash, ttl)
+		}
+	}
+
 	visited, err := c.store.IsVisited(uHash)
 	if err != nil {
 		return err
@@ -309,24 +404,30 @@ func (c *Collector) checkVistedStatus(parsedURL *url.URL) error {
 	return c.store.Visited(uHash)
 }
 
-func (c *Collector) isDomainAllowed(domain string) bool {
-	for _, d2 := range c.disallowedDomains {
-		if d2 == domain {
-			return false
-		}
+// checkVisitTime allows the visited URL if ttl passed since its last visit
+func (c *Collector) checkVisitTime(ts storage.VisitTimeStorage, parsedURL *url.URL, uHash uint64, ttl time.Duration) error {
+	last, err := ts.LastVisit(uHash)
+	if err != nil {
+		return err
 	}
 
-	if c.allowedDomains == nil || len(c.allowedDomains) == 0 {
-		return true
+	if !last.IsZero() && time.Since(last) < ttl {
+		return &AlreadyVisitedError{parsedURL}
 	}
 
-	for _, d2 := range c.allowedDomains {
-		if d2 == domain {
-			return true
-		}
+	return ts.VisitedAt(uHash, time.Now())
+}
+
+func (c *Collector) isDomainAllowed(u *url.URL) bool {
+	if c.disallowedMatcher != nil && c.disallowedMatcher.match(u) {
+		return false
+	}
+
+	if c.allowedMatcher == nil {
+		return true
 	}
 
-	return false
+	return c.allowedMatcher.match(u)
 }
 
 func (c *Collector) handleIgnoredErrors(err error) error {
@@ -356,146 +457,95 @@ func (c *Collector) handleIgnoredErrors(err error) error {
 - OnError
 **/
 
-// OnRequest
-func (c *Collector) OnRequest(f RequestCallback) {
-	c.lock.Lock()
-
-	if c.requestCallbacks == nil {
-		c.requestCallbacks = make([]RequestCallback, 0, _capacity)
-	}
-
-	c.requestCallbacks = append(c.requestCallbacks, f)
-	c.lock.Unlock()
+// OnRequest registers a function. Function will be executed before
+// navigating to the URL.
+func (c *Collector) OnRequest(f RequestCallback, opts ...CallbackOptionFunc) *CallbackHandle {
+	opt := newCallbackOptions(opts...)
+	return c.requestCallbacks.add(f, opt.priority)
 }
 
 // OnResponse handle on response.
-func (c *Collector) OnResponse(f ResponseCallback) {
-	c.lock.Lock()
-
-	if c.responseCallbacks == nil {
-		c.responseCallbacks = make([]ResponseCallback, 0, _capacity)
-	}
-
-	c.responseCallbacks = append(c.responseCallbacks, f)
-
-	c.lock.Unlock()
+func (c *Collector) OnResponse(f ResponseCallback, opts ...CallbackOptionFunc) *CallbackHandle {
+	opt := newCallbackOptions(opts...)
+	return c.responseCallbacks.add(f, opt.priority)
 }
 
-func (c *Collector) OnHTML(selector string, f HTMLCallback, opts ...CallbackOptionFunc) {
-	c.lock.Lock()
-
-	opt := CallbackOptions{deferFunc: func(p *rod.Page) {}}
-	bindCallbackOptions(&opt, opts...)
-
-	if c.htmlCallbacks == nil {
-		c.htmlCallbacks = make([]*htmlCallbackContainer, 0, _capacity)
-	}
+func (c *Collector) OnHTML(selector string, f HTMLCallback, opts ...CallbackOptionFunc) *CallbackHandle {
+	opt := newCallbackOptions(opts...)
 
-	c.htmlCallbacks = append(c.htmlCallbacks, &htmlCallbackContainer{
+	return c.htmlCallbacks.add(&htmlCallbackContainer{
 		Selector:  selector,
 		Function:  f,
 		DeferFunc: opt.deferFunc,
-	})
-
-	c.lock.Unlock()
+		Filter:    newCallbackFilter(opt),
+		Optional:  opt.optional,
+		WaitFor:   opt.waitFor,
+	}, opt.priority)
 }
 
 // OnHTMLDetach deregister a function. Function will not be execute after detached
 func (c *Collector) OnHTMLDetach(goquerySelector string) {
-	c.lock.Lock()
-	defer c.lock.Unlock()
-
-	deleteIdx := -1
-
-	for i, cc := range c.htmlCallbacks {
-		if cc.Selector == goquerySelector {
-			deleteIdx = i
-			break
-		}
-	}
-
-	if deleteIdx != -1 {
-		c.htmlCallbacks = append(c.htmlCallbacks[:deleteIdx], c.htmlCallbacks[deleteIdx+1:]...)
+	found := c.htmlCallbacks.removeFirst(func(cc *htmlCallbackContainer) bool {
+		return cc.Selector == goquerySelector
+	})
 
+	if found {
 		log.Info().Str("selector", goquerySelector).Msg("detached handler on")
 	}
 }
 
-func (c *Collector) OnData(selector string, f DataCallback) {
-	c.lock.Lock()
-
-	if c.dataCallbacks == nil {
-		c.dataCallbacks = make([]*dataCallbackContainer, 0, _capacity)
-	}
+func (c *Collector) OnData(selector string, f DataCallback, opts ...CallbackOptionFunc) *CallbackHandle {
+	opt := newCallbackOptions(opts...)
 
-	c.dataCallbacks = append(c.dataCallbacks, &dataCallbackContainer{
+	return c.dataCallbacks.add(&dataCallbackContainer{
 		Selector: selector,
 		Function: f,
-	})
-	c.lock.Unlock()
+		Filter:   newCallbackFilter(opt),
+	}, opt.priority)
 }
 
-func (c *Collector) OnPaging(selector string, f HTMLCallback, opts ...CallbackOptionFunc) {
-	c.lock.Lock()
-
-	opt := CallbackOptions{deferFunc: func(p *rod.Page) {}}
-	bindCallbackOptions(&opt, opts...)
+func (c *Collector) OnPaging(selector string, f HTMLCallback, opts ...CallbackOptionFunc) *CallbackHandle {
+	opt := newCallbackOptions(opts...)
 
-	if c.pagingCallbacks == nil {
-		c.pagingCallbacks = make([]*htmlCallbackContainer, 0, _capacity)
-	}
-
-	c.pagingCallbacks = append(c.pagingCallbacks, &htmlCallbackContainer{
+	return c.pagingCallbacks.add(&htmlCallbackContainer{
 		Selector:  selector,
 		Function:  f,
 		DeferFunc: opt.deferFunc,
-	})
-
-	c.lock.Unlock()
+		Filter:    newCallbackFilter(opt),
+		Optional:  opt.optional,
+		WaitFor:   opt.waitFor,
+	}, opt.priority)
 }
 
 // OnError registers a function. Function will be executed if an error
 // occurs during the HTTP request.
-func (c *Collector) OnError(f ErrorCallback) {
-	c.lock.Lock()
-
-	if c.errorCallbacks == nil {
-		c.errorCallbacks = make([]ErrorCallback, 0, _capacity)
-	}
-
-	c.errorCallbacks = append(c.errorCallbacks, f)
-
-	c.lock.Unlock()
+func (c *Collector) OnError(f ErrorCallback, opts ...CallbackOptionFunc) *CallbackHandle {
+	opt := newCallbackOptions(opts...)
+	return c.errorCallbacks.add(f, opt.priority)
 }
 
 // OnScraped registers a function. Function will be executed after
 // OnHTML, as a final part of the scraping.
-func (c *Collector) OnScraped(f ScrapedCallback) {
-	c.lock.Lock()
-
-	if c.scrapedCallbacks == nil {
-		c.scrapedCallbacks = make([]ScrapedCallback, 0, 4)
-	}
-
-	c.scrapedCallbacks = append(c.scrapedCallbacks, f)
-
-	c.lock.Unlock()
+func (c *Collector) OnScraped(f ScrapedCallback, opts ...CallbackOptionFunc) *CallbackHandle {
+	opt := newCallbackOptions(opts...)
+	return c.scrapedCallbacks.add(f, opt.priority)
 }
 
 func (c *Collector) handleOnRequest(r *Request) {
-	for _, f := range c.requestCallbacks {
+	c.requestCallbacks.each(func(f RequestCallback) {
 		f(r)
-	}
+	})
 }
 
 func (c *Collector) handleOnResponse(r *Response) {
-	for _, f := range c.responseCallbacks {
+	c.responseCallbacks.each(func(f ResponseCallback) {
 		f(r)
-	}
+	})
 }
 
 func (c *Collector) handleOnData(resp *Response) error {
-	if len(c.dataCallbacks) == 0 {
+	callbacks := c.dataCallbacks.snapshot()
+	if len(callbacks) == 0 {
 		return nil
 	}
 
@@ -504,24 +554,27 @@ func (c *Collector) handleOnData(resp *Response) error {
 		return err
 	}
 
-	// try parse base from
-	if href, found := doc.Find("base[href]").Attr("href"); found {
-		u, err := urlParser.ParseRef(resp.Request.URL.String(), href)
-		if err == nil {
-			baseURL, err := url.Parse(u.Href(false))
-			if err == nil {
-				resp.Request.baseURL = baseURL
-			}
+	setBaseURL(doc, resp.Request)
+
+	for _, entry := range callbacks {
+		cb := entry.value
+		if entry.detached.Load() || !cb.Filter.applies(resp.Request) {
+			continue
+		}
+
+		sel := doc.Find(cb.Selector)
+		if sel.Length() == 0 || !cb.Filter.fire() {
+			continue
 		}
-	}
 
-	for _, cb := range c.dataCallbacks {
 		cbIndex := 0
 
-		doc.Find(cb.Selector).Each(func(_ int, s *goquery.Selection) {
+		sel.Each(func(_ int, s *goquery.Selection) {
 			for _, n := range s.Nodes {
 				e := NewHTMLElement(resp, s, n, cbIndex)
 				cbIndex++
+
+				c.emitCallback(EventCallbackRun, resp.Request, "data", cb.Selector, nil)
 				cb.Function(e)
 			}
 		})
@@ -531,14 +584,14 @@ func (c *Collector) handleOnData(resp *Response) error {
 }
 
 func (c *Collector) handleOnHTML(resp *Response) error {
-	return c.handleOnSerp(resp, c.htmlCallbacks)
+	return c.handleOnSerp(resp, "html", c.htmlCallbacks.snapshot())
 }
 
 func (c *Collector) handleOnPaging(resp *Response) error {
-	return c.handleOnSerp(resp, c.pagingCallbacks)
+	return c.handleOnSerp(resp, "paging", c.pagingCallbacks.snapshot())
 }
 
-func (c *Collector) handleOnSerp(resp *Response, callbacks []*htmlCallbackContainer) error {
+func (c *Collector) handleOnSerp(resp *Response, kind string, callbacks []*callbackEntry[*htmlCallbackContainer]) error {
 	if len(callbacks) == 0 {
 		return nil
 	}
@@ -546,17 +599,21 @@ func (c *Collector) handleOnSerp(resp *Response, callbacks []*htmlCallbackContai
 	finalDepth := resp.Request.Depth >= c.maxDepth
 	request := resp.Request
 
-	for cbIndex, cb := range callbacks {
-		// after current page's elements are handled, go back
-		if cb.DeferFunc != nil {
-			defer cb.DeferFunc(resp.Page)
+	for cbIndex, entry := range callbacks {
+		cb := entry.value
+		if entry.detached.Load() || !cb.Filter.applies(request) {
+			continue
 		}
 
 		bot := xbot.NewBotWithPage(resp.Page)
 
-		elem := bot.GetElem(cb.Selector)
-		if elem == nil {
-			return fmt.Errorf("%s: %s: %w", request.String(), cb.Selector, ErrNoElemFound)
+		if !c.waitForSelector(bot, cb) {
+			if cb.Optional {
+				log.Debug().Str("selector", cb.Selector).Str("request", request.String()).Msg("optional selector not found, skip")
+				continue
+			}
+
+			return &MissingSelectorError{URL: request.URL.String(), Selector: cb.Selector, Callback: kind}
 		}
 
 		count := len(bot.GetElems(cb.Selector))
@@ -569,6 +626,15 @@ func (c *Collector) handleOnSerp(resp *Response, callbacks []*htmlCallbackContai
 			continue
 		}
 
+		if !cb.Filter.fire() {
+			continue
+		}
+
+		// after current page's elements are handled, go back
+		if cb.DeferFunc != nil {
+			defer cb.DeferFunc(resp.Page)
+		}
+
 		for i := 0; i < count; i++ {
 			// WARN: elems are not accessable after page is changed, we have to re-get all elements, then get correct elem by index.
 			elem := bot.GetElem(cb.Selector)
@@ -608,8 +674,13 @@ func (c *Collector) handleOnSerp(resp *Response, callbacks []*htmlCallbackContai
 
 			log.Trace().Str("with", target).Str("from", parent).Msg(msg)
 
+			c.emitCallback(EventCallbackRun, request, kind, cb.Selector, nil)
+
 			err := cb.Function(e)
 			if err != nil {
+				c.emitCallback(EventCallbackError, request, kind, cb.Selector, err)
+				cb.Filter.misfire()
+
 				return err
 			}
 		}
@@ -618,6 +689,23 @@ func (c *Collector) handleOnSerp(resp *Response, callbacks []*htmlCallbackContai
 	return nil
 }
 
+// waitForSelector returns true if the selector of cb is found, it polls until cb.WaitFor passed.
+func (c *Collector) waitForSelector(bot *xbot.Bot, cb *htmlCallbackContainer) bool {
+	deadline := time.Now().Add(cb.WaitFor)
+
+	for {
+		if bot.GetElem(cb.Selector) != nil {
+			return true
+		}
+
+		if !time.Now().Before(deadline) {
+			return false
+		}
+
+		time.Sleep(min(_selectorPollInterval, time.Until(deadline)))
+	}
+}
+
 func (c *Collector) handleOnError(response *Response, err error, request *Request, ctx *Context) error {
 	err = c.handleIgnoredErrors(err)
 
@@ -640,17 +728,19 @@ func (c *Collector) handleOnError(response *Response, err error, request *Reques
 		response.Ctx = request.Ctx
 	}
 
-	for _, f := range c.errorCallbacks {
+	c.errorCallbacks.each(func(f ErrorCallback) {
 		f(response, err)
-	}
+	})
 
 	return err
 }
 
 func (c *Collector) handleOnScraped(r *Response) {
-	for _, f := range c.scrapedCallbacks {
+	c.scrapedCallbacks.each(func(f ScrapedCallback) {
 		f(r)
-	}
+	})
+
+	c.emitRequest(EventScraped, r.Request, nil)
 }
 
 func (c *Collector) UnmarshalRequest(r []byte) (*Request, error) {
@@ -666,14 +756,17 @@ func (c *Collector) UnmarshalRequest(r []byte) (*Request, error) {
 		return nil, err
 	}
 
-	ctx := NewContext()
-	for k, v := range req.Ctx {
-		ctx.Put(k, v)
+	ctx := req.Ctx
+	if ctx == nil {
+		ctx = NewContext()
 	}
 
 	return &Request{
 		URL:       u,
 		Depth:     req.Depth,
+		Priority:  req.Priority,
+		Attempts:  req.Attempts,
+		NotBefore: req.NotBefore,
 		Ctx:       ctx,
 		ID:        atomic.AddUint32(&c.requestCount, 1),
 		collector: c,
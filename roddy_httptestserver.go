@@ -2,9 +2,11 @@ package roddy
 
 import (
 	"bufio"
+	"compress/gzip"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"time"
 )
 
@@ -15,6 +17,32 @@ User-agent: *
 Allow: /allowed
 Disallow: /disallowed
 Disallow: /allowed*q=
+Sitemap: /sitemap_index.xml # relative is resolved
+`
+	sitemapIndex = `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap><loc>/sitemap_pages.xml</loc><lastmod>2024-03-01</lastmod></sitemap>
+	<sitemap><loc>/sitemap_news.xml.gz</loc><lastmod>2024-01-01T00:00:00+00:00</lastmod></sitemap>
+	<sitemap><loc>/sitemap_index.xml</loc></sitemap>
+</sitemapindex>
+`
+	sitemapBrokenIndex = `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap><loc>/500</loc></sitemap>
+	<sitemap><loc>/sitemap_pages.xml</loc></sitemap>
+</sitemapindex>
+`
+	sitemapPages = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>/html</loc><lastmod>2024-03-01T10:00:00Z</lastmod></url>
+	<url><loc>/xml</loc><lastmod>2023-12-01</lastmod></url>
+	<url><loc>/allowed</loc></url>
+</urlset>
+`
+	sitemapNews = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>/news/1</loc><lastmod>2024-01-01</lastmod></url>
+</urlset>
 `
 )
 
@@ -65,6 +93,32 @@ func newUnstartedTestServer() *httptest.Server {
 		w.Write([]byte(robotsFile))
 	})
 
+	mux.HandleFunc("/sitemap_index.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(sitemapIndex))
+	})
+
+	mux.HandleFunc("/sitemap_broken.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(sitemapBrokenIndex))
+	})
+
+	mux.HandleFunc("/sitemap_pages.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(sitemapPages))
+	})
+
+	mux.HandleFunc("/sitemap_news.xml.gz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(sitemapNews))
+		gz.Close()
+	})
+
+	mux.HandleFunc("/sitemap.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("/html\n\n/xml\n"))
+	})
+
 	mux.HandleFunc("/allowed", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
 		w.Write([]byte("allowed"))
@@ -202,6 +256,43 @@ y">link</a>
 		}
 	})
 
+	// /late renders div.late 500ms after load
+	mux.HandleFunc("/late", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<!DOCTYPE html><html><head><title>Late</title></head><body>
+<script>
+setTimeout(() => {
+	const div = document.createElement("div");
+	div.className = "late";
+	div.textContent = "rendered";
+	document.body.appendChild(div);
+}, 500);
+</script>
+</body></html>`))
+	})
+
+	// /list has 3 pages of items, the later pages are empty
+	mux.HandleFunc("/list", func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<!DOCTYPE html><html><head><title>List</title></head><body><ul>")
+
+		if page >= 1 && page <= 3 {
+			for i := 1; i <= 2; i++ {
+				fmt.Fprintf(w, `<li class="item">item %d-%d</li>`, page, i)
+			}
+		}
+
+		fmt.Fprint(w, "</ul>")
+
+		if page >= 1 && page < 3 {
+			fmt.Fprintf(w, `<a class="next" href="/list?page=%d">Next</a>`, page+1)
+		}
+
+		fmt.Fprint(w, "</body></html>")
+	})
+
 	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
 
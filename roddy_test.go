@@ -1,9 +1,16 @@
 package roddy
 
 import (
+	"fmt"
+	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
+
+	"roddy/storage"
 
 	"github.com/coghost/xlog"
 	"github.com/rs/zerolog"
@@ -137,6 +144,121 @@ func (s *RoddySuite) Test_13_VisitDisallowedDomains() {
 	// TODO:
 }
 
+func (s *RoddySuite) Test_14_RevisitAfter() {
+	c := NewCollector(
+		RevisitAfter(time.Hour),
+		RevisitAfterFor(regexp.MustCompile(`/list`), time.Minute),
+		RevisitAfterFor(regexp.MustCompile(`/detail/`), 0),
+	)
+
+	ts := c.store.(storage.VisitTimeStorage)
+
+	for _, tt := range []struct {
+		uri      string
+		lastSeen time.Duration
+		allowed  bool
+	}{
+		{"http://example.com/", 30 * time.Minute, false},
+		{"http://example.com/other", 2 * time.Hour, true},
+		{"http://example.com/list", 2 * time.Minute, true},
+		{"http://example.com/list?page=2", 30 * time.Second, false},
+		{"http://example.com/detail/1", 240 * time.Hour, false},
+	} {
+		u, _ := ParseUrl(tt.uri)
+		s.Nil(ts.VisitedAt(requestHash(u.String(), nil), time.Now().Add(-tt.lastSeen)))
+
+		err := c.checkVistedStatus(u)
+		if tt.allowed {
+			s.Nil(err, tt.uri)
+		} else {
+			s.IsType(&AlreadyVisitedError{}, err, tt.uri)
+		}
+	}
+
+	u, _ := ParseUrl("http://example.com/new")
+	s.Nil(c.checkVistedStatus(u))
+	s.IsType(&AlreadyVisitedError{}, c.checkVistedStatus(u), "visit time is recorded")
+}
+
+func (s *RoddySuite) Test_15_Sitemap() {
+	c := NewCollector()
+
+	locs := func(entries []*SitemapEntry) []string {
+		var paths []string
+		for _, e := range entries {
+			paths = append(paths, e.Loc[len(s.ts.URL):])
+		}
+
+		return paths
+	}
+
+	for _, uri := range []string{s.ts.URL, s.ts.URL + "/robots.txt", s.ts.URL + "/sitemap_index.xml"} {
+		entries, err := c.FetchSitemap(uri)
+		s.Nil(err, uri)
+		s.Equal([]string{"/html", "/xml", "/allowed", "/news/1"}, locs(entries), uri)
+		s.Equal(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), entries[0].LastMod)
+		s.Equal(s.ts.URL+"/sitemap_news.xml.gz", entries[3].Sitemap)
+
+		lastMod, ok := GetAs[time.Time](entries[0].Context(), SitemapLastModKey)
+		s.True(ok)
+		s.Equal(entries[0].LastMod, lastMod)
+		s.Nil(entries[2].Context().GetAny(SitemapLastModKey), "no lastmod")
+	}
+
+	entries, err := c.FetchSitemap(s.ts.URL + "/sitemap.txt")
+	s.Nil(err)
+	s.Equal([]string{"/html", "/xml"}, locs(entries))
+
+	entries, err = c.FetchSitemap(s.ts.URL, SitemapSince(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)))
+	s.Nil(err)
+	s.Equal([]string{"/html", "/allowed"}, locs(entries), "old entries and sitemaps are skipped")
+
+	entries, err = c.FetchSitemap(s.ts.URL+"/sitemap_index.xml", SitemapSinceLastCrawl())
+	s.Nil(err)
+	s.Len(entries, 4)
+
+	entries, err = c.FetchSitemap(s.ts.URL+"/sitemap_index.xml", SitemapSinceLastCrawl())
+	s.Nil(err)
+	s.Empty(entries, "no sitemap is modified since the last crawl")
+
+	_, err = c.FetchSitemap(s.ts.URL + "/500")
+	s.ErrorContains(err, "unexpected status 500")
+
+	entries, err = c.FetchSitemap(s.ts.URL+"/sitemap_broken.xml", SitemapSinceLastCrawl())
+	s.ErrorContains(err, "sitemap "+s.ts.URL+"/500: unexpected status 500")
+	s.Equal([]string{"/html", "/xml", "/allowed"}, locs(entries), "a failing sitemap doesn't stop the others")
+
+	entries, err = c.FetchSitemap(s.ts.URL+"/sitemap_broken.xml", SitemapSinceLastCrawl())
+	s.Error(err)
+	s.Len(entries, 3, "last crawl isn't recorded when a sitemap failed")
+}
+
+func (s *RoddySuite) Test_16_SitemapClient() {
+	var got []string
+
+	// the proxy serves the sitemap of any host
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sid, _ := r.Cookie("sid")
+		got = append(got, fmt.Sprintf("%s %s %v", r.URL.String(), r.UserAgent(), sid))
+
+		http.SetCookie(w, &http.Cookie{Name: "seen", Value: "1"})
+		w.Write([]byte(`<urlset><url><loc>/a</loc></url></urlset>`))
+	}))
+	defer proxy.Close()
+
+	c := NewCollector(WithProxies(strings.TrimPrefix(proxy.URL, "http://")), UserAgent("roddy-test"))
+
+	u, _ := url.Parse("http://sitemap.example/sitemap.xml")
+	c.store.SetCookies(u, "sid=1")
+
+	entries, err := c.FetchSitemap(u.String())
+	s.Nil(err)
+	s.Len(entries, 1)
+	s.Equal([]string{"http://sitemap.example/sitemap.xml roddy-test sid=1"}, got)
+	s.Contains(c.store.Cookies(u), "seen=1")
+	s.Contains(c.store.Cookies(u), "sid=1")
+}
+
 func (s *RoddySuite) Test_20_OnHTML() {
 	c := NewCollector()
 
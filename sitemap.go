@@ -0,0 +1,460 @@
+package roddy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"roddy/storage"
+
+	"github.com/gookit/goutil/arrutil"
+	"github.com/rs/zerolog/log"
+)
+
+// SitemapLastModKey is the Ctx key of the <lastmod> of requests created by VisitSitemap,
+// the value is a time.Time, and it's absent if the sitemap has no <lastmod>.
+const SitemapLastModKey = "lastmod"
+
+const (
+	// the protocol limits a sitemap to 50MB uncompressed
+	_maxSitemapSize = 50 << 20
+	// salt of the request hash, which records the last crawl of a sitemap
+	_sitemapHashSalt = "sitemap"
+
+	_sitemapTimeout = 30 * time.Second
+)
+
+var (
+	// ErrNoSitemap is returned when robots.txt has no Sitemap line and /sitemap.xml is absent
+	ErrNoSitemap = errors.New("No sitemap found")
+
+	_lastModLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04Z07:00",
+		"2006-01-02T15:04:05",
+		"2006-01-02",
+		"2006-01",
+		"2006",
+	}
+)
+
+// SitemapEntry is a <url> of a sitemap
+type SitemapEntry struct {
+	Loc string
+	// LastMod is zero if the entry has no <lastmod>
+	LastMod time.Time
+	// Sitemap is the URL of the sitemap containing the entry
+	Sitemap string
+}
+
+type sitemapOptions struct {
+	since          time.Time
+	sinceLastCrawl bool
+}
+
+// SitemapOption configures FetchSitemap and VisitSitemap
+type SitemapOption func(*sitemapOptions)
+
+// SitemapSince skips the entries and child sitemaps whose <lastmod> is before t,
+// the ones without <lastmod> are always kept.
+func SitemapSince(t time.Time) SitemapOption {
+	return func(o *sitemapOptions) {
+		o.since = t
+	}
+}
+
+// SitemapSinceLastCrawl skips the entries not modified since the last crawl of the same sitemap URL.
+// The crawl time is kept in the collector storage, so it only works with a storage.VisitTimeStorage.
+func SitemapSinceLastCrawl() SitemapOption {
+	return func(o *sitemapOptions) {
+		o.sinceLastCrawl = true
+	}
+}
+
+type sitemapXML struct {
+	URLs     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+type sitemapLoc struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// VisitSitemap visits every <loc> of the sitemap at URL with its <lastmod> in Ctx, see FetchSitemap
+// for the accepted URLs. The requests are made as Visit does, so the entries are queued in async mode.
+func (c *Collector) VisitSitemap(URL string, opts ...SitemapOption) error {
+	entries, err := c.FetchSitemap(URL, opts...)
+	if err != nil && len(entries) == 0 {
+		return err
+	}
+
+	errs := []error{err}
+
+	for _, e := range entries {
+		if err := c.scrape(e.Loc, 1, e.Context()); err != nil && !IsSkipError(err) {
+			log.Error().Err(err).Str("url", e.Loc).Msg("cannot visit sitemap entry")
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// FetchSitemap returns the entries of the sitemap at URL, the URL can be
+//   - a sitemap or sitemap index, in XML or plain text, gzipped or not
+//   - a robots.txt, or a site root, then sitemaps are discovered from the Sitemap lines of robots.txt,
+//     falling back to /sitemap.xml
+//
+// Sitemap indexes are followed recursively, each sitemap is fetched once. A failing sitemap
+// doesn't stop the others, the entries found are returned with the joined errors.
+func (c *Collector) FetchSitemap(URL string, opts ...SitemapOption) ([]*SitemapEntry, error) {
+	o := &sitemapOptions{}
+	for _, fn := range opts {
+		fn(o)
+	}
+
+	u, err := ParseUrl(URL)
+	if err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	crawlHash := requestHash(u.String(), strings.NewReader(_sitemapHashSalt))
+
+	if o.sinceLastCrawl {
+		if err := c.applyLastCrawl(o, crawlHash); err != nil {
+			return nil, err
+		}
+	}
+
+	sitemaps, err := c.discoverSitemaps(u)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		entries []*SitemapEntry
+		errs    []error
+	)
+
+	seen := make(map[string]bool)
+
+	for len(sitemaps) > 0 {
+		sm := sitemaps[0]
+		sitemaps = sitemaps[1:]
+
+		if seen[sm] {
+			continue
+		}
+
+		seen[sm] = true
+
+		urls, children, err := c.fetchSitemap(sm, o)
+		if err != nil {
+			log.Error().Err(err).Str("sitemap", sm).Msg("cannot fetch sitemap")
+			errs = append(errs, fmt.Errorf("sitemap %s: %w", sm, err))
+
+			continue
+		}
+
+		entries = append(entries, urls...)
+		sitemaps = append(sitemaps, children...)
+	}
+
+	// the crawl time isn't recorded if a sitemap failed, so its entries are fetched next time
+	if o.sinceLastCrawl && len(errs) == 0 {
+		if ts, ok := c.store.(storage.VisitTimeStorage); ok {
+			return entries, ts.VisitedAt(crawlHash, started)
+		}
+	}
+
+	return entries, errors.Join(errs...)
+}
+
+// Context returns a new Context with the LastMod of entry
+func (e *SitemapEntry) Context() *Context {
+	ctx := NewContext()
+	if !e.LastMod.IsZero() {
+		ctx.Put(SitemapLastModKey, e.LastMod)
+	}
+
+	return ctx
+}
+
+// applyLastCrawl moves o.since to the last crawl time if it's later
+func (c *Collector) applyLastCrawl(o *sitemapOptions, crawlHash uint64) error {
+	ts, ok := c.store.(storage.VisitTimeStorage)
+	if !ok {
+		log.Warn().Msg("storage doesn't record visit time, SitemapSinceLastCrawl is ignored")
+		return nil
+	}
+
+	last, err := ts.LastVisit(crawlHash)
+	if err != nil {
+		return err
+	}
+
+	if last.After(o.since) {
+		o.since = last
+	}
+
+	return nil
+}
+
+// discoverSitemaps returns u itself if it's not a robots.txt or site root
+func (c *Collector) discoverSitemaps(u *url.URL) ([]string, error) {
+	switch u.Path {
+	case "", "/":
+		u = u.ResolveReference(&url.URL{Path: "/robots.txt"})
+	case "/robots.txt":
+	default:
+		return []string{u.String()}, nil
+	}
+
+	body, status, err := c.getSitemapResource(u.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var sitemaps []string
+
+	if status == http.StatusOK {
+		sitemaps = robotsSitemaps(body, u)
+	}
+
+	if len(sitemaps) > 0 {
+		return sitemaps, nil
+	}
+
+	fallback := u.ResolveReference(&url.URL{Path: "/sitemap.xml"}).String()
+
+	if _, status, err := c.getSitemapResource(fallback); err != nil || status != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrNoSitemap, u)
+	}
+
+	return []string{fallback}, nil
+}
+
+// robotsSitemaps returns the URLs of Sitemap lines, relative URLs are resolved against robots.txt
+func robotsSitemaps(robots []byte, base *url.URL) []string {
+	var sitemaps []string
+
+	sc := bufio.NewScanner(bytes.NewReader(robots))
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), "sitemap") {
+			continue
+		}
+
+		value, _, _ = strings.Cut(value, "#")
+
+		ref, err := url.Parse(strings.TrimSpace(value))
+		if err != nil || ref.String() == "" {
+			continue
+		}
+
+		sitemaps = append(sitemaps, base.ResolveReference(ref).String())
+	}
+
+	return sitemaps
+}
+
+// fetchSitemap returns the entries and child sitemaps of the sitemap at URL
+func (c *Collector) fetchSitemap(URL string, o *sitemapOptions) ([]*SitemapEntry, []string, error) {
+	body, status, err := c.getSitemapResource(URL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if status != http.StatusOK {
+		return nil, nil, fmt.Errorf("unexpected status %d", status)
+	}
+
+	base, err := url.Parse(URL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return nil, nil, nil
+	}
+
+	// text sitemaps are one URL per line
+	if trimmed[0] != '<' {
+		var entries []*SitemapEntry
+
+		for _, line := range strings.Split(string(trimmed), "\n") {
+			if loc := resolveLoc(base, line); loc != "" {
+				entries = append(entries, &SitemapEntry{Loc: loc, Sitemap: URL})
+			}
+		}
+
+		return entries, nil, nil
+	}
+
+	doc := &sitemapXML{}
+	if err := xml.Unmarshal(trimmed, doc); err != nil {
+		return nil, nil, err
+	}
+
+	var entries []*SitemapEntry
+
+	for _, v := range doc.URLs {
+		loc := resolveLoc(base, v.Loc)
+		if loc == "" {
+			continue
+		}
+
+		e := &SitemapEntry{Loc: loc, LastMod: parseLastMod(v.LastMod), Sitemap: URL}
+		if isModifiedSince(e.LastMod, o.since) {
+			entries = append(entries, e)
+		}
+	}
+
+	var children []string
+
+	for _, v := range doc.Sitemaps {
+		loc := resolveLoc(base, v.Loc)
+		if loc != "" && isModifiedSince(parseLastMod(v.LastMod), o.since) {
+			children = append(children, loc)
+		}
+	}
+
+	log.Debug().Str("sitemap", URL).Int("urls", len(entries)).Int("sitemaps", len(children)).Msg("sitemap fetched")
+
+	return entries, children, nil
+}
+
+// sitemapClient returns the client of sitemaps and robots.txt,
+// it uses the proxies and the cookies of the collector as the browser does.
+func (c *Collector) sitemapClient() *http.Client {
+	c.httpClientOnce.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = c.randomProxy
+
+		c.httpClient = &http.Client{
+			Timeout:   _sitemapTimeout,
+			Transport: transport,
+			Jar:       &storeJar{c: c},
+		}
+	})
+
+	return c.httpClient
+}
+
+// randomProxy picks a proxy of the collector as newBot does, proxies are host:port without scheme.
+func (c *Collector) randomProxy(*http.Request) (*url.URL, error) {
+	if len(c.proxies) == 0 {
+		return nil, nil
+	}
+
+	proxy := arrutil.RandomOne(c.proxies)
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+
+	return url.Parse(proxy)
+}
+
+// storeJar is the http.CookieJar of the collector storage
+type storeJar struct {
+	c *Collector
+}
+
+func (j *storeJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	merged := make([]*http.Cookie, len(cookies))
+	copy(merged, cookies)
+
+	for _, old := range storage.UnstringifyCookies(j.c.store.Cookies(u)) {
+		if !storage.ContainsCookie(merged, old.Name) {
+			merged = append(merged, old)
+		}
+	}
+
+	j.c.store.SetCookies(u, storage.StringifyCookies(merged))
+}
+
+func (j *storeJar) Cookies(u *url.URL) []*http.Cookie {
+	return storage.UnstringifyCookies(j.c.store.Cookies(u))
+}
+
+// getSitemapResource gets URL with plain HTTP, as sitemaps need no rendering,
+// gzipped body is decompressed.
+func (c *Collector) getSitemapResource(URL string) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, URL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req.Header.Set("User-Agent", c.userAgent)
+
+	resp, err := c.sitemapClient().Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	br := bufio.NewReader(resp.Body)
+
+	var r io.Reader = br
+
+	// check the magic number, as servers send .gz files with all kinds of Content-Type
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, resp.StatusCode, err
+		}
+		defer gz.Close()
+
+		r = gz
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r, _maxSitemapSize))
+
+	return body, resp.StatusCode, err
+}
+
+func resolveLoc(base *url.URL, loc string) string {
+	loc = strings.TrimSpace(loc)
+	if loc == "" {
+		return ""
+	}
+
+	ref, err := url.Parse(loc)
+	if err != nil {
+		return ""
+	}
+
+	return base.ResolveReference(ref).String()
+}
+
+// parseLastMod parses the W3C Datetime of <lastmod>, zero time is returned if invalid
+func parseLastMod(s string) time.Time {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}
+	}
+
+	for _, layout := range _lastModLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// isModifiedSince returns true if lastMod is unknown or not before since
+func isModifiedSince(lastMod, since time.Time) bool {
+	return lastMod.IsZero() || since.IsZero() || !lastMod.Before(since)
+}
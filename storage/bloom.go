@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/bits-and-blooms/bloom/v3"
+)
+
+// BloomStorage keeps visited request IDs in a bloom filter, the memory is fixed
+// by the expected number of IDs, but IsVisited may report an unvisited request
+// as visited at the false positive rate.
+type BloomStorage struct {
+	// ExpectedN is the expected number of visited requests
+	ExpectedN uint
+	// FPRate is the false positive rate at ExpectedN requests
+	FPRate float64
+	// Path is the file the filter is loaded from in Init and saved to in Close,
+	// the filter is not persisted if Path is empty.
+	Path string
+
+	filter *bloom.BloomFilter
+	lock   *sync.RWMutex
+	jar    *cookiejar.Jar
+}
+
+// NewBloomStorage creates a BloomStorage sized for expectedN requests at fpRate.
+func NewBloomStorage(expectedN uint, fpRate float64) *BloomStorage {
+	return &BloomStorage{
+		ExpectedN: expectedN,
+		FPRate:    fpRate,
+	}
+}
+
+// Init initializes BloomStorage, and loads the filter from Path if it exists
+func (s *BloomStorage) Init() error {
+	if s.lock == nil {
+		s.lock = &sync.RWMutex{}
+	}
+
+	if s.filter == nil {
+		s.filter = bloom.NewWithEstimates(s.ExpectedN, s.FPRate)
+
+		if err := s.load(); err != nil {
+			return err
+		}
+	}
+
+	if s.jar == nil {
+		var err error
+		s.jar, err = cookiejar.New(nil)
+
+		return err
+	}
+
+	return nil
+}
+
+// Visited implements Storage.Visited()
+func (s *BloomStorage) Visited(requestID uint64) error {
+	s.lock.Lock()
+	s.filter.Add(idBytes(requestID))
+	s.lock.Unlock()
+
+	return nil
+}
+
+// IsVisited implements Storage.IsVisited()
+func (s *BloomStorage) IsVisited(requestID uint64) (bool, error) {
+	s.lock.RLock()
+	visited := s.filter.Test(idBytes(requestID))
+	s.lock.RUnlock()
+
+	return visited, nil
+}
+
+// Cookies implements Storage.Cookies()
+func (s *BloomStorage) Cookies(u *url.URL) string {
+	return StringifyCookies(s.jar.Cookies(u))
+}
+
+// SetCookies implements Storage.SetCookies()
+func (s *BloomStorage) SetCookies(u *url.URL, cookies string) {
+	s.jar.SetCookies(u, UnstringifyCookies(cookies))
+}
+
+// Save writes the filter to Path
+func (s *BloomStorage) Save() error {
+	if s.Path == "" {
+		return nil
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0o755); err != nil {
+		return err
+	}
+
+	tmp := s.Path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.filter.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// rename is atomic, a crash never leaves a partial filter
+	return os.Rename(tmp, s.Path)
+}
+
+// Close saves the filter to Path. The collector doesn't close its storage,
+// so Close must be called when the crawl is done to persist the filter.
+func (s *BloomStorage) Close() error {
+	return s.Save()
+}
+
+func (s *BloomStorage) load() error {
+	if s.Path == "" {
+		return nil
+	}
+
+	f, err := os.Open(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = s.filter.ReadFrom(f)
+
+	return err
+}
+
+func idBytes(id uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+
+	return b
+}
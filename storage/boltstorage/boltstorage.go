@@ -0,0 +1,378 @@
+// Package boltstorage is an embedded key-value backend implementing both
+// storage.Storage and queue.Storage in a single bbolt file.
+package boltstorage
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"roddy/storage"
+
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	_sizeKey = []byte("queue_size")
+
+	// bbolt holds a file lock per open, so databases are shared by path in one process.
+	registry   = map[string]*DB{}
+	registryMu sync.Mutex
+)
+
+// DB is a bbolt database which can be shared by multiple collectors and queues.
+type DB struct {
+	path string
+	refs int
+	db   *bolt.DB
+
+	mu sync.Mutex
+	// recovered holds the prefixes whose inflight requests are moved back to
+	// the queue, it's done once per open, as the others may be in flight now.
+	recovered map[string]bool
+}
+
+// Open opens the database at path, opening the same path again returns the
+// same DB, and the file is closed when every Open is paired with a Close.
+func Open(path string) (*DB, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if d, ok := registry[abs]; ok {
+		d.refs++
+		return d, nil
+	}
+
+	db, err := bolt.Open(abs, 0o644, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DB{path: abs, refs: 1, db: db, recovered: make(map[string]bool)}
+	registry[abs] = d
+
+	return d, nil
+}
+
+// Close releases the DB, closing a closed DB does nothing.
+func (d *DB) Close() error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if d.refs == 0 {
+		return nil
+	}
+
+	d.refs--
+	if d.refs > 0 {
+		return nil
+	}
+
+	delete(registry, d.path)
+
+	return d.db.Close()
+}
+
+// Storage returns a Storage of namespace prefix,
+// collectors and queues using different prefixes are independent.
+func (d *DB) Storage(prefix string) *Storage {
+	return &Storage{Prefix: prefix, DB: d}
+}
+
+// Storage implements the bbolt storage backend for roddy,
+// it can be used as both storage.Storage and queue.AckStorage.
+type Storage struct {
+	// Prefix is the namespace of the buckets
+	Prefix string
+	// MaxSize defines the capacity of the queue.
+	// New requests are discarded if the queue size reaches MaxSize
+	MaxSize int
+	// DB is the shared database
+	DB *DB
+}
+
+// Init creates the buckets. The first Init of a Prefix after Open puts the requests
+// left in flight by a previous run back to the queue, at their original position.
+func (s *Storage) Init() error {
+	s.DB.mu.Lock()
+	defer s.DB.mu.Unlock()
+
+	firstInit := !s.DB.recovered[s.Prefix]
+
+	err := s.DB.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range s.buckets() {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+
+		if !firstInit {
+			return nil
+		}
+
+		return s.recoverInflight(tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.DB.recovered[s.Prefix] = true
+
+	return nil
+}
+
+// recoverInflight moves the inflight requests back to the queue
+func (s *Storage) recoverInflight(tx *bolt.Tx) error {
+	inflight := tx.Bucket(s.inflightBucket())
+	queue := tx.Bucket(s.queueBucket())
+	n := 0
+
+	cur := inflight.Cursor()
+	for k, v := cur.First(); k != nil; k, v = cur.First() {
+		if err := queue.Put(k, v); err != nil {
+			return err
+		}
+
+		if err := cur.Delete(); err != nil {
+			return err
+		}
+
+		n++
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	return s.setSize(tx, s.size(tx)+n)
+}
+
+// Clear removes all entries of Prefix
+func (s *Storage) Clear() error {
+	err := s.DB.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range s.buckets() {
+			if err := tx.DeleteBucket(name); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.Init()
+}
+
+// Visited implements storage.Storage.Visited()
+func (s *Storage) Visited(requestID uint64) error {
+	return s.VisitedAt(requestID, time.Now())
+}
+
+// VisitedAt implements storage.VisitTimeStorage.VisitedAt()
+func (s *Storage) VisitedAt(requestID uint64, t time.Time) error {
+	return s.DB.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.visitedBucket()).Put(itob(requestID), itob(uint64(t.UnixNano())))
+	})
+}
+
+// LastVisit implements storage.VisitTimeStorage.LastVisit(),
+// the visits recorded without time are treated as visited at the Unix epoch.
+func (s *Storage) LastVisit(requestID uint64) (time.Time, error) {
+	var t time.Time
+
+	err := s.DB.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(s.visitedBucket()).Get(itob(requestID))
+
+		switch len(v) {
+		case 0:
+		case 8:
+			t = time.Unix(0, int64(binary.BigEndian.Uint64(v)))
+		default:
+			t = time.Unix(0, 0)
+		}
+
+		return nil
+	})
+
+	return t, err
+}
+
+// IsVisited implements storage.Storage.IsVisited()
+func (s *Storage) IsVisited(requestID uint64) (bool, error) {
+	visited := false
+
+	err := s.DB.db.View(func(tx *bolt.Tx) error {
+		visited = tx.Bucket(s.visitedBucket()).Get(itob(requestID)) != nil
+		return nil
+	})
+
+	return visited, err
+}
+
+// Cookies implements storage.Storage.Cookies()
+func (s *Storage) Cookies(u *url.URL) string {
+	cookies := ""
+
+	err := s.DB.db.View(func(tx *bolt.Tx) error {
+		cookies = string(tx.Bucket(s.cookieBucket()).Get([]byte(u.Host)))
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Str("host", u.Host).Msg("cannot get cookies")
+	}
+
+	return cookies
+}
+
+// SetCookies implements storage.Storage.SetCookies()
+func (s *Storage) SetCookies(u *url.URL, cookies string) {
+	err := s.DB.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.cookieBucket()).Put([]byte(u.Host), []byte(cookies))
+	})
+	if err != nil {
+		log.Error().Err(err).Str("host", u.Host).Msg("cannot set cookies")
+	}
+}
+
+// AddRequest implements queue.Storage.AddRequest()
+func (s *Storage) AddRequest(r []byte) error {
+	return s.DB.db.Update(func(tx *bolt.Tx) error {
+		size := s.size(tx)
+		if s.MaxSize > 0 && size >= s.MaxSize {
+			return storage.ErrQueueFull
+		}
+
+		b := tx.Bucket(s.queueBucket())
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		if err := b.Put(itob(seq), r); err != nil {
+			return err
+		}
+
+		return s.setSize(tx, size+1)
+	})
+}
+
+// GetRequest implements queue.Storage.GetRequest()
+func (s *Storage) GetRequest() ([]byte, error) {
+	r, _, err := s.pop(false)
+	return r, err
+}
+
+// GetRequestAck implements queue.AckStorage.GetRequestAck(),
+// the request is kept in the inflight bucket until it's acknowledged.
+func (s *Storage) GetRequestAck() ([]byte, uint64, error) {
+	return s.pop(true)
+}
+
+// Ack implements queue.AckStorage.Ack()
+func (s *Storage) Ack(token uint64) error {
+	return s.DB.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.inflightBucket()).Delete(itob(token))
+	})
+}
+
+func (s *Storage) pop(inflight bool) ([]byte, uint64, error) {
+	var (
+		r     []byte
+		token uint64
+	)
+
+	err := s.DB.db.Update(func(tx *bolt.Tx) error {
+		cur := tx.Bucket(s.queueBucket()).Cursor()
+
+		k, v := cur.First()
+		if k == nil {
+			return nil
+		}
+
+		// k and v are only valid in the transaction
+		r = append([]byte(nil), v...)
+		token = binary.BigEndian.Uint64(k)
+
+		if inflight {
+			if err := tx.Bucket(s.inflightBucket()).Put(itob(token), r); err != nil {
+				return err
+			}
+		}
+
+		if err := cur.Delete(); err != nil {
+			return err
+		}
+
+		return s.setSize(tx, s.size(tx)-1)
+	})
+
+	return r, token, err
+}
+
+// QueueSize implements queue.Storage.QueueSize()
+func (s *Storage) QueueSize() (int, error) {
+	size := 0
+
+	err := s.DB.db.View(func(tx *bolt.Tx) error {
+		size = s.size(tx)
+		return nil
+	})
+
+	return size, err
+}
+
+func (s *Storage) size(tx *bolt.Tx) int {
+	v := tx.Bucket(s.metaBucket()).Get(_sizeKey)
+	if v == nil {
+		return 0
+	}
+
+	return int(binary.BigEndian.Uint64(v))
+}
+
+func (s *Storage) setSize(tx *bolt.Tx, n int) error {
+	return tx.Bucket(s.metaBucket()).Put(_sizeKey, itob(uint64(n)))
+}
+
+func (s *Storage) buckets() [][]byte {
+	return [][]byte{s.visitedBucket(), s.cookieBucket(), s.queueBucket(), s.inflightBucket(), s.metaBucket()}
+}
+
+func (s *Storage) visitedBucket() []byte {
+	return []byte(s.Prefix + ":visited")
+}
+
+func (s *Storage) cookieBucket() []byte {
+	return []byte(s.Prefix + ":cookie")
+}
+
+func (s *Storage) queueBucket() []byte {
+	return []byte(s.Prefix + ":queue")
+}
+
+func (s *Storage) inflightBucket() []byte {
+	return []byte(s.Prefix + ":inflight")
+}
+
+func (s *Storage) metaBucket() []byte {
+	return []byte(s.Prefix + ":meta")
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+
+	return b
+}
@@ -0,0 +1,279 @@
+package boltstorage
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"roddy/storage"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type BoltSuite struct {
+	suite.Suite
+	path string
+}
+
+func TestBolt(t *testing.T) {
+	suite.Run(t, new(BoltSuite))
+}
+
+func (s *BoltSuite) SetupTest() {
+	s.path = filepath.Join(s.T().TempDir(), "roddy.db")
+}
+
+func (s *BoltSuite) open(prefix string) (*DB, *Storage) {
+	db, err := Open(s.path)
+	s.Require().Nil(err)
+
+	st := db.Storage(prefix)
+	s.Require().Nil(st.Init())
+
+	return db, st
+}
+
+func (s *BoltSuite) Test_00_Visited() {
+	db, st := s.open("c1")
+	defer db.Close()
+
+	visited, err := st.IsVisited(1)
+	s.Nil(err)
+	s.False(visited)
+
+	s.Nil(st.Visited(1))
+
+	visited, err = st.IsVisited(1)
+	s.Nil(err)
+	s.True(visited)
+
+	at := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	s.Nil(st.VisitedAt(2, at))
+
+	last, err := st.LastVisit(2)
+	s.Nil(err)
+	s.True(at.Equal(last))
+
+	last, err = st.LastVisit(3)
+	s.Nil(err)
+	s.True(last.IsZero(), "not visited")
+
+	u, _ := url.Parse("https://example.com/a")
+	s.Equal("", st.Cookies(u))
+
+	st.SetCookies(u, "a=1; b=2")
+	s.Equal("a=1; b=2", st.Cookies(u))
+
+	other, _ := url.Parse("https://other.example.com/")
+	s.Equal("", st.Cookies(other), "cookies are kept per host")
+}
+
+func (s *BoltSuite) Test_01_Queue() {
+	db, st := s.open("q1")
+	defer db.Close()
+
+	st.MaxSize = 3
+
+	for i := 0; i < 3; i++ {
+		s.Nil(st.AddRequest([]byte(fmt.Sprint(i))))
+	}
+
+	s.ErrorIs(st.AddRequest([]byte("3")), storage.ErrQueueFull)
+
+	size, err := st.QueueSize()
+	s.Nil(err)
+	s.Equal(3, size)
+
+	for i := 0; i < 3; i++ {
+		r, err := st.GetRequest()
+		s.Nil(err)
+		s.Equal(fmt.Sprint(i), string(r), "FIFO order")
+	}
+
+	r, err := st.GetRequest()
+	s.Nil(err)
+	s.Nil(r, "empty queue")
+
+	size, _ = st.QueueSize()
+	s.Equal(0, size)
+
+	s.Nil(st.AddRequest([]byte("4")), "room after pops")
+}
+
+func (s *BoltSuite) Test_02_Prefix() {
+	db, a := s.open("a")
+	defer db.Close()
+
+	b := db.Storage("b")
+	s.Nil(b.Init())
+
+	s.Nil(a.Visited(1))
+	s.Nil(a.AddRequest([]byte("a")))
+
+	visited, _ := b.IsVisited(1)
+	s.False(visited)
+
+	size, _ := b.QueueSize()
+	s.Equal(0, size)
+
+	s.Nil(a.Clear())
+
+	visited, _ = a.IsVisited(1)
+	s.False(visited, "cleared")
+
+	size, _ = a.QueueSize()
+	s.Equal(0, size)
+
+	s.Nil(b.Visited(2))
+	s.Nil(a.Clear())
+
+	visited, _ = b.IsVisited(2)
+	s.True(visited, "clearing a prefix keeps the others")
+}
+
+func (s *BoltSuite) Test_03_SharedOpen() {
+	db1, err := Open(s.path)
+	s.Require().Nil(err)
+
+	db2, err := Open(filepath.Join(filepath.Dir(s.path), ".", filepath.Base(s.path)))
+	s.Require().Nil(err)
+	s.Same(db1, db2, "same path is shared")
+
+	st := db1.Storage("shared")
+	s.Nil(st.Init())
+	s.Nil(db1.Close())
+
+	// db2 still holds the file
+	s.Nil(st.Visited(1))
+
+	visited, err := db2.Storage("shared").IsVisited(1)
+	s.Nil(err)
+	s.True(visited)
+
+	s.Nil(db2.Close())
+
+	db3, err := Open(s.path)
+	s.Require().Nil(err)
+	s.NotSame(db1, db3, "reopened after the last Close")
+	s.Nil(db3.Close())
+}
+
+func (s *BoltSuite) Test_04_Persistence() {
+	db, st := s.open("p")
+
+	s.Nil(st.Visited(7))
+	s.Nil(st.AddRequest([]byte("first")))
+	s.Nil(st.AddRequest([]byte("second")))
+
+	r, err := st.GetRequest()
+	s.Nil(err)
+	s.Equal("first", string(r))
+
+	u, _ := url.Parse("https://example.com/")
+	st.SetCookies(u, "sid=1")
+
+	s.Nil(db.Close())
+
+	db, st = s.open("p")
+	defer db.Close()
+
+	visited, _ := st.IsVisited(7)
+	s.True(visited)
+	s.Equal("sid=1", st.Cookies(u))
+
+	size, _ := st.QueueSize()
+	s.Equal(1, size)
+
+	r, err = st.GetRequest()
+	s.Nil(err)
+	s.Equal("second", string(r))
+}
+
+func (s *BoltSuite) Test_05_Ack() {
+	db, st := s.open("a")
+
+	for _, r := range []string{"r1", "r2", "r3"} {
+		s.Nil(st.AddRequest([]byte(r)))
+	}
+
+	r1, t1, err := st.GetRequestAck()
+	s.Nil(err)
+	s.Equal("r1", string(r1))
+
+	r2, t2, err := st.GetRequestAck()
+	s.Nil(err)
+	s.Equal("r2", string(r2))
+
+	size, _ := st.QueueSize()
+	s.Equal(1, size, "popped requests are not counted")
+
+	s.Nil(st.Ack(t2))
+	s.Nil(db.Close())
+
+	// r1 was in flight at the crash
+	db, st = s.open("a")
+	defer db.Close()
+
+	size, _ = st.QueueSize()
+	s.Equal(2, size)
+
+	for _, want := range []string{"r1", "r3"} {
+		r, token, err := st.GetRequestAck()
+		s.Nil(err)
+		s.Equal(want, string(r))
+		s.Nil(st.Ack(token))
+	}
+
+	s.NotEqual(t1, t2)
+
+	r, _, err := st.GetRequestAck()
+	s.Nil(err)
+	s.Nil(r)
+}
+
+func (s *BoltSuite) Test_06_SharedInflight() {
+	db, st := s.open("s")
+
+	for _, r := range []string{"r1", "r2"} {
+		s.Nil(st.AddRequest([]byte(r)))
+	}
+
+	r, _, err := st.GetRequestAck()
+	s.Nil(err)
+	s.Equal("r1", string(r))
+
+	// other users of the prefix don't take r1 from the running one
+	other := db.Storage("s")
+	s.Nil(other.Init())
+
+	db2, third := s.open("s")
+	s.Same(db, db2)
+
+	size, _ := third.QueueSize()
+	s.Equal(1, size, "r1 is still in flight")
+
+	s.Nil(db2.Close())
+	s.Nil(db.Close())
+
+	db, st = s.open("s")
+	defer db.Close()
+
+	size, _ = st.QueueSize()
+	s.Equal(2, size, "r1 is recovered after reopen")
+}
+
+func (s *BoltSuite) Test_07_CloseTwice() {
+	db, _ := s.open("c")
+
+	s.Nil(db.Close())
+	s.Nil(db.Close())
+	s.Zero(db.refs)
+
+	db2, st := s.open("c")
+	defer db2.Close()
+
+	s.NotSame(db, db2)
+	s.Nil(st.Visited(1))
+}
@@ -0,0 +1,415 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/bits-and-blooms/bloom/v3"
+)
+
+const (
+	_defaultMemLimit = 1 << 20
+	_defaultMaxRuns  = 16
+	// false positive rate of the filter in front of each run file
+	_runFPRate = 0.01
+)
+
+// ExactStorage keeps visited request IDs without false positives. Recent IDs are
+// kept in memory, and spilled to sorted run files in Dir when MemLimit is reached.
+// Each run file has a bloom filter in memory, so most lookups of unvisited IDs
+// don't touch the disk. The run files are kept between runs.
+type ExactStorage struct {
+	// Dir is the directory of the run files
+	Dir string
+	// MemLimit is the number of IDs kept in memory before spilling to disk
+	MemLimit int
+	// MaxRuns is the number of run files which triggers merging them into one
+	MaxRuns int
+
+	lock    *sync.RWMutex
+	mem     map[uint64]struct{}
+	runs    []*visitedRun
+	nextRun int
+	jar     *cookiejar.Jar
+}
+
+// visitedRun is a file of sorted big-endian IDs
+type visitedRun struct {
+	path   string
+	f      *os.File
+	n      int64
+	filter *bloom.BloomFilter
+}
+
+// NewExactStorage creates an ExactStorage spilling to dir.
+func NewExactStorage(dir string) *ExactStorage {
+	return &ExactStorage{
+		Dir:      dir,
+		MemLimit: _defaultMemLimit,
+		MaxRuns:  _defaultMaxRuns,
+	}
+}
+
+// Init initializes ExactStorage, and opens the run files of previous runs,
+// MemLimit and MaxRuns are defaulted if not set.
+func (s *ExactStorage) Init() error {
+	if s.lock == nil {
+		s.lock = &sync.RWMutex{}
+	}
+
+	if s.MemLimit <= 0 {
+		s.MemLimit = _defaultMemLimit
+	}
+
+	if s.MaxRuns <= 0 {
+		s.MaxRuns = _defaultMaxRuns
+	}
+
+	if s.mem == nil {
+		s.mem = make(map[uint64]struct{})
+
+		if err := s.openRuns(); err != nil {
+			return err
+		}
+	}
+
+	if s.jar == nil {
+		var err error
+		s.jar, err = cookiejar.New(nil)
+
+		return err
+	}
+
+	return nil
+}
+
+// Visited implements Storage.Visited()
+func (s *ExactStorage) Visited(requestID uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.mem[requestID]; ok {
+		return nil
+	}
+
+	s.mem[requestID] = struct{}{}
+
+	if len(s.mem) < s.MemLimit {
+		return nil
+	}
+
+	if err := s.spill(); err != nil {
+		return err
+	}
+
+	if len(s.runs) > s.MaxRuns {
+		return s.merge()
+	}
+
+	return nil
+}
+
+// IsVisited implements Storage.IsVisited()
+func (s *ExactStorage) IsVisited(requestID uint64) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if _, ok := s.mem[requestID]; ok {
+		return true, nil
+	}
+
+	key := idBytes(requestID)
+
+	for _, r := range s.runs {
+		if !r.filter.Test(key) {
+			continue
+		}
+
+		found, err := r.contains(requestID)
+		if err != nil || found {
+			return found, err
+		}
+	}
+
+	return false, nil
+}
+
+// Cookies implements Storage.Cookies()
+func (s *ExactStorage) Cookies(u *url.URL) string {
+	return StringifyCookies(s.jar.Cookies(u))
+}
+
+// SetCookies implements Storage.SetCookies()
+func (s *ExactStorage) SetCookies(u *url.URL, cookies string) {
+	s.jar.SetCookies(u, UnstringifyCookies(cookies))
+}
+
+// Close spills the IDs in memory to disk and closes the run files. The collector
+// doesn't close its storage, so Close must be called when the crawl is done,
+// otherwise the IDs visited since the last spill are lost.
+func (s *ExactStorage) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var errs []error
+	if len(s.mem) > 0 {
+		errs = append(errs, s.spill())
+	}
+
+	for _, r := range s.runs {
+		errs = append(errs, r.f.Close())
+	}
+
+	s.runs = nil
+
+	return errors.Join(errs...)
+}
+
+func (s *ExactStorage) runPath(n int) string {
+	return filepath.Join(s.Dir, fmt.Sprintf("visited.%06d.run", n))
+}
+
+func (s *ExactStorage) openRuns() error {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+
+	// leftover of an interrupted spill or merge
+	tmps, _ := filepath.Glob(filepath.Join(s.Dir, "visited.*.tmp"))
+	for _, t := range tmps {
+		os.Remove(t)
+	}
+
+	// the fixed width names are sorted by the run number
+	paths, err := filepath.Glob(filepath.Join(s.Dir, "visited.*.run"))
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		var n int
+		if _, err := fmt.Sscanf(filepath.Base(p), "visited.%06d.run", &n); err != nil {
+			return fmt.Errorf("invalid run file %s: %w", p, err)
+		}
+
+		r, err := openRun(p)
+		if err != nil {
+			return err
+		}
+
+		s.runs = append(s.runs, r)
+		s.nextRun = n + 1
+	}
+
+	return nil
+}
+
+// spill writes the IDs in memory to a new run file
+func (s *ExactStorage) spill() error {
+	ids := make([]uint64, 0, len(s.mem))
+	for id := range s.mem {
+		ids = append(ids, id)
+	}
+
+	slices.Sort(ids)
+
+	r, err := s.writeRun(func(w io.Writer) error {
+		for _, id := range ids {
+			if _, err := w.Write(idBytes(id)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.runs = append(s.runs, r)
+	s.mem = make(map[uint64]struct{})
+
+	return nil
+}
+
+// merge merges all run files into one
+func (s *ExactStorage) merge() error {
+	r, err := s.writeRun(func(w io.Writer) error {
+		return mergeRuns(s.runs, w)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, old := range s.runs {
+		old.f.Close()
+		os.Remove(old.path)
+	}
+
+	s.runs = []*visitedRun{r}
+
+	return nil
+}
+
+// writeRun writes the next run file by fn, the file is renamed in place only after it's complete.
+func (s *ExactStorage) writeRun(fn func(w io.Writer) error) (*visitedRun, error) {
+	path := s.runPath(s.nextRun)
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(f)
+	if err := fn(w); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+
+	s.nextRun++
+
+	return openRun(path)
+}
+
+// mergeRuns writes the sorted union of runs to w
+func mergeRuns(runs []*visitedRun, w io.Writer) error {
+	readers := make([]*bufio.Reader, len(runs))
+	heads := make([]uint64, len(runs))
+	alive := make([]bool, len(runs))
+
+	buf := make([]byte, 8)
+
+	next := func(i int) error {
+		if _, err := io.ReadFull(readers[i], buf); err != nil {
+			if errors.Is(err, io.EOF) {
+				alive[i] = false
+				return nil
+			}
+
+			return err
+		}
+
+		heads[i] = binary.BigEndian.Uint64(buf)
+		alive[i] = true
+
+		return nil
+	}
+
+	for i, r := range runs {
+		readers[i] = bufio.NewReader(io.NewSectionReader(r.f, 0, r.n*8))
+		if err := next(i); err != nil {
+			return err
+		}
+	}
+
+	written := false
+
+	var last uint64
+
+	for {
+		least := -1
+
+		for i := range runs {
+			if alive[i] && (least == -1 || heads[i] < heads[least]) {
+				least = i
+			}
+		}
+
+		if least == -1 {
+			return nil
+		}
+
+		if !written || heads[least] != last {
+			if _, err := w.Write(idBytes(heads[least])); err != nil {
+				return err
+			}
+
+			last = heads[least]
+			written = true
+		}
+
+		if err := next(least); err != nil {
+			return err
+		}
+	}
+}
+
+func openRun(path string) (*visitedRun, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	r := &visitedRun{path: path, f: f, n: info.Size() / 8}
+	r.filter = bloom.NewWithEstimates(uint(max(r.n, 1)), _runFPRate)
+
+	br := bufio.NewReader(io.NewSectionReader(f, 0, r.n*8))
+	buf := make([]byte, 8)
+
+	for i := int64(0); i < r.n; i++ {
+		if _, err := io.ReadFull(br, buf); err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		r.filter.Add(buf)
+	}
+
+	return r, nil
+}
+
+// contains binary searches id in the run file
+func (r *visitedRun) contains(id uint64) (bool, error) {
+	buf := make([]byte, 8)
+	lo, hi := int64(0), r.n
+
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		if _, err := r.f.ReadAt(buf, mid*8); err != nil {
+			return false, err
+		}
+
+		v := binary.BigEndian.Uint64(buf)
+
+		switch {
+		case v == id:
+			return true, nil
+		case v < id:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+
+	return false, nil
+}
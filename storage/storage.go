@@ -15,13 +15,19 @@
 package storage
 
 import (
+	"errors"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 )
 
+// ErrQueueFull is the error returned by queue storages when the queue is full,
+// it's defined here so storage backends don't depend on the collector package.
+var ErrQueueFull = errors.New("Queue MaxSize reached")
+
 // Storage is an interface which handles Collector's internal data,
 // like visited urls and cookies.
 // The default Storage of the Collector is the InMemoryStorage.
@@ -41,11 +47,21 @@ type Storage interface {
 	SetCookies(u *url.URL, cookies string)
 }
 
+// VisitTimeStorage is an optional interface of Storage,
+// it records the last visit time, so a visited URL can be revisited later.
+type VisitTimeStorage interface {
+	// VisitedAt stores the visit time of a request ID
+	VisitedAt(requestID uint64, t time.Time) error
+	// LastVisit returns the last visit time of a request ID,
+	// zero time is returned if the request is never visited
+	LastVisit(requestID uint64) (time.Time, error)
+}
+
 // InMemoryStorage is the default storage backend of colly.
 // InMemoryStorage keeps cookies and visited urls in memory
 // without persisting data on the disk.
 type InMemoryStorage struct {
-	visitedURLs map[uint64]bool
+	visitedURLs map[uint64]time.Time
 	lock        *sync.RWMutex
 	jar         *cookiejar.Jar
 }
@@ -53,7 +69,7 @@ type InMemoryStorage struct {
 // Init initializes InMemoryStorage
 func (s *InMemoryStorage) Init() error {
 	if s.visitedURLs == nil {
-		s.visitedURLs = make(map[uint64]bool)
+		s.visitedURLs = make(map[uint64]time.Time)
 	}
 
 	if s.lock == nil {
@@ -72,20 +88,34 @@ func (s *InMemoryStorage) Init() error {
 
 // Visited implements Storage.Visited()
 func (s *InMemoryStorage) Visited(requestID uint64) error {
+	return s.VisitedAt(requestID, time.Now())
+}
+
+// IsVisited implements Storage.IsVisited()
+func (s *InMemoryStorage) IsVisited(requestID uint64) (bool, error) {
+	s.lock.RLock()
+	_, visited := s.visitedURLs[requestID]
+	s.lock.RUnlock()
+
+	return visited, nil
+}
+
+// VisitedAt implements VisitTimeStorage.VisitedAt()
+func (s *InMemoryStorage) VisitedAt(requestID uint64, t time.Time) error {
 	s.lock.Lock()
-	s.visitedURLs[requestID] = true
+	s.visitedURLs[requestID] = t
 	s.lock.Unlock()
 
 	return nil
 }
 
-// IsVisited implements Storage.IsVisited()
-func (s *InMemoryStorage) IsVisited(requestID uint64) (bool, error) {
+// LastVisit implements VisitTimeStorage.LastVisit()
+func (s *InMemoryStorage) LastVisit(requestID uint64) (time.Time, error) {
 	s.lock.RLock()
-	visited := s.visitedURLs[requestID]
+	t := s.visitedURLs[requestID]
 	s.lock.RUnlock()
 
-	return visited, nil
+	return t, nil
 }
 
 // Cookies implements Storage.Cookies()
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type StorageSuite struct {
+	suite.Suite
+}
+
+func TestStorage(t *testing.T) {
+	suite.Run(t, new(StorageSuite))
+}
+
+func (s *StorageSuite) Test_00_BloomStorage() {
+	path := filepath.Join(s.T().TempDir(), "visited.bloom")
+
+	st := NewBloomStorage(1000, 0.001)
+	st.Path = path
+	s.Nil(st.Init())
+
+	for i := uint64(0); i < 100; i++ {
+		s.Nil(st.Visited(i))
+	}
+
+	s.Nil(st.Close())
+
+	// reload the persisted filter
+	st = NewBloomStorage(1000, 0.001)
+	st.Path = path
+	s.Nil(st.Init())
+
+	for i := uint64(0); i < 100; i++ {
+		visited, err := st.IsVisited(i)
+		s.Nil(err)
+		s.True(visited)
+	}
+
+	visited, _ := st.IsVisited(1 << 40)
+	s.False(visited)
+}
+
+func (s *StorageSuite) Test_01_ExactStorage() {
+	dir := s.T().TempDir()
+
+	st := NewExactStorage(dir)
+	st.MemLimit = 4
+	st.MaxRuns = 2
+	s.Nil(st.Init())
+
+	for i := uint64(0); i < 100; i += 2 {
+		s.Nil(st.Visited(i))
+		s.Nil(st.Visited(i), "visit twice")
+	}
+
+	s.LessOrEqual(len(st.runs), 3, "runs are merged")
+
+	check := func(st *ExactStorage) {
+		for i := uint64(0); i < 100; i++ {
+			visited, err := st.IsVisited(i)
+			s.Nil(err)
+			s.Equal(i%2 == 0, visited, i)
+		}
+	}
+
+	check(st)
+	s.Nil(st.Close())
+
+	// IDs in memory are spilled on Close, and the runs are reopened,
+	// limits of a literal ExactStorage are defaulted
+	st = &ExactStorage{Dir: dir}
+	s.Nil(st.Init())
+	s.Equal(_defaultMemLimit, st.MemLimit)
+	s.Equal(_defaultMaxRuns, st.MaxRuns)
+	check(st)
+	s.Nil(st.Visited(1))
+	s.Len(st.mem, 1, "not spilled")
+	s.Nil(st.Close())
+}